@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/go-martini/martini"
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/martini-contrib/render"
+
+	"crypto/sha1"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Badge colors, picked by how full a counter is.
+const (
+	badgeGreen  = "#4c1"
+	badgeYellow = "#dfb317"
+	badgeRed    = "#e05d44"
+	badgeBlue   = "#007ec6"
+	badgeGrey   = "#9f9f9f"
+)
+
+// badgeColor is blue without a capacity, and green, yellow from 75% and red
+// from 90% full with one.
+func badgeColor(capacity *Capacity) string {
+	switch {
+	case capacity == nil:
+		return badgeBlue
+	case capacity.PercentFull >= 90:
+		return badgeRed
+	case capacity.PercentFull >= 75:
+		return badgeYellow
+	default:
+		return badgeGreen
+	}
+}
+
+// badgeHandler renders "label: count" as an SVG badge in the style of
+// shields.io. "total" stands for the total of all forms unless a counter
+// has that name. Badges are cached for the cache TTL.
+func badgeHandler(cache *countCache) func(render.Render, http.ResponseWriter, *http.Request, martini.Params) {
+	return func(r render.Render, w http.ResponseWriter, req *http.Request, params martini.Params) {
+		name := params["counter"]
+		label := req.URL.Query().Get("label")
+		if len(label) < 1 {
+			label = name
+		}
+
+		var selector formSelector
+		capacity := wufooConfig.Capacity
+		if counter, ok := wufooConfig.Counter(name); ok {
+			selector, capacity = wufooConfig.counterSelector(counter), counter.Capacity
+		} else if name != "total" {
+			r.JSON(404, map[string]interface{}{"error": "unknown counter"})
+			return
+		}
+
+		value, color := "unavailable", badgeGrey
+		maxAge := int(wufooConfig.CacheTTL.Seconds())
+		if count, err := cache.Get(req.Context(), selector); err == nil {
+			c := newCapacity(count, capacity)
+			value, color = strconv.Itoa(count), badgeColor(c)
+			if c != nil {
+				value += " / " + strconv.Itoa(c.Capacity)
+			}
+		} else {
+			maxAge = 0
+		}
+
+		svg := badgeSVG(label, value, color)
+		etag := fmt.Sprintf(`"%x"`, sha1.Sum(svg))
+		w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+		w.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			r.Status(http.StatusNotModified)
+			return
+		}
+		r.Data(200, svg)
+	}
+}
+
+// badgeSVG draws the badge. Text widths are estimated, which is close
+// enough for the digits and short labels badges are made of.
+func badgeSVG(label, value, color string) []byte {
+	labelWidth, valueWidth := textWidth(label), textWidth(value)
+	width := labelWidth + valueWidth
+	label, value = html.EscapeString(label), html.EscapeString(value)
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, width, labelWidth, valueWidth, label, value, color, labelWidth/2, labelWidth+valueWidth/2))
+}
+
+func textWidth(text string) int {
+	return utf8.RuneCountInString(text)*7 + 10
+}
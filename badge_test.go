@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBadge(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants", "coaches"}}},
+		Counters: []CounterConfig{{Name: "applicants", Forms: []string{"applicants"}, Capacity: 40}},
+		CacheTTL: time.Minute,
+		Retry:    RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetCount("applicants", 36)
+	fake.SetCount("coaches", 5)
+
+	res := fetchPage(cache, "/badge/applicants.svg?label=<applicants>")
+	body := res.Body.String()
+	if res.Code != 200 || res.Header().Get("Content-Type") != "image/svg+xml; charset=utf-8" {
+		t.Fatalf("expected an SVG, got %d %q", res.Code, res.Header().Get("Content-Type"))
+	}
+	if res.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("unexpected Cache-Control %q", res.Header().Get("Cache-Control"))
+	}
+	for _, expected := range []string{"&lt;applicants&gt;: 36 / 40", badgeRed} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected the badge to contain %s", expected)
+		}
+	}
+
+	if body := fetchPage(cache, "/badge/total.svg").Body.String(); !strings.Contains(body, "total: 41") || !strings.Contains(body, badgeBlue) {
+		t.Errorf("expected a blue total badge, got %s", body)
+	}
+	if res := fetchPage(cache, "/badge/nope.svg"); res.Code != 404 {
+		t.Errorf("expected 404 for an unknown counter, got %d", res.Code)
+	}
+
+	req, _ := http.NewRequest("GET", "/badge/applicants.svg", nil)
+	req.Header.Set("If-None-Match", fetchPage(cache, "/badge/applicants.svg").Header().Get("ETag"))
+	notModified := httptest.NewRecorder()
+	newServer(cache).ServeHTTP(notModified, req)
+	if notModified.Code != 304 {
+		t.Errorf("expected 304 for a matching ETag, got %d", notModified.Code)
+	}
+}
+
+func TestBadgeUnavailable(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		Retry:    RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetError("", &wufoo.Error{Code: wufoo.ErrAuthFailed, Err: errors.New("unexpected status 401")})
+
+	res := fetchPage(cache, "/badge/total.svg")
+	if res.Code != 200 || !strings.Contains(res.Body.String(), "total: unavailable") || res.Header().Get("Cache-Control") != "public, max-age=0" {
+		t.Errorf("expected an uncached grey badge, got %d %q %s", res.Code, res.Header().Get("Cache-Control"), res.Body.String())
+	}
+}
+
+func TestBadgeColor(t *testing.T) {
+	for capacity, color := range map[*Capacity]string{
+		nil:                 badgeBlue,
+		newCapacity(29, 40): badgeGreen,
+		newCapacity(30, 40): badgeYellow,
+		newCapacity(36, 40): badgeRed,
+		newCapacity(50, 40): badgeRed,
+	} {
+		if c := badgeColor(capacity); c != color {
+			t.Errorf("%v: expected %s, got %s", capacity, color, c)
+		}
+	}
+}
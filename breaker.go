@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// circuitBreaker stops requests to an account after threshold consecutive
+// failures. Once cooldown has passed a single probe is let through; its
+// outcome closes the circuit again or keeps it open for another cooldown.
+// Other requests to the account wait for that outcome.
+type circuitBreaker struct {
+	sync.Mutex
+
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+	// probed is closed once the running probe has been recorded.
+	probed chan struct{}
+}
+
+func newCircuitBreaker(name string, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{name: name, threshold: threshold, cooldown: cooldown, state: CircuitClosed}
+}
+
+// Allow reports whether a request may be sent now. While a probe is
+// running it waits for its outcome, or gives up once ctx is done.
+func (b *circuitBreaker) Allow(ctx context.Context) bool {
+	for {
+		b.Lock()
+		switch b.state {
+		case CircuitOpen:
+			if time.Since(b.openedAt) < b.cooldown {
+				b.Unlock()
+				return false
+			}
+			b.state = CircuitHalfOpen
+			log.Printf("circuit of %s half-open, probing Wufoo", b.name)
+			b.startProbe()
+			b.Unlock()
+			return true
+		case CircuitHalfOpen:
+			if !b.probing {
+				b.startProbe()
+				b.Unlock()
+				return true
+			}
+			probed := b.probed
+			b.Unlock()
+			select {
+			case <-probed:
+			case <-ctx.Done():
+				return false
+			}
+		default:
+			b.Unlock()
+			return true
+		}
+	}
+}
+
+func (b *circuitBreaker) startProbe() {
+	b.probing = true
+	b.probed = make(chan struct{})
+}
+
+// Record feeds the outcome of an allowed request back. Only failures that
+// point at Wufoo being unavailable count. A cancelled request, recorded
+// with the error of its context, counts neither way but lets the next
+// request probe.
+func (b *circuitBreaker) Record(err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.probing {
+		b.probing = false
+		close(b.probed)
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return
+	}
+	if we, ok := err.(*wufoo.Error); !ok || !we.Retryable {
+		if b.state != CircuitClosed {
+			log.Printf("circuit of %s closed", b.name)
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		if b.state != CircuitOpen {
+			log.Printf("circuit of %s open for %s after %d failures", b.name, b.cooldown, b.failures)
+		}
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) State() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+// breakers holds one circuit breaker per account.
+var breakers = map[string]*circuitBreaker{}
+
+func setupBreakers(config WufooConfig) {
+	for _, account := range config.Accounts {
+		breakers[account.Account] = newCircuitBreaker(account.Account, config.BreakerThreshold, config.BreakerCooldown)
+	}
+}
+
+// circuitStates maps every account to the state of its circuit breaker.
+func circuitStates() map[string]string {
+	states := map[string]string{}
+	for name, breaker := range breakers {
+		states[name] = breaker.State()
+	}
+	return states
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errUnavailable = &wufoo.Error{Code: wufoo.ErrUpstream, Err: errors.New("unexpected status 500"), Retryable: true}
+	errNotFound    = &wufoo.Error{Code: wufoo.ErrFormNotFound, Err: errors.New("unexpected status 404")}
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes []error
+		// wait lets the cooldown pass before the last outcome.
+		wait  bool
+		state string
+	}{
+		{"success", []error{nil}, false, CircuitClosed},
+		{"below threshold", []error{errUnavailable, errUnavailable}, false, CircuitClosed},
+		{"threshold", []error{errUnavailable, errUnavailable, errUnavailable}, false, CircuitOpen},
+		{"success resets failures", []error{errUnavailable, errUnavailable, nil, errUnavailable, errUnavailable}, false, CircuitClosed},
+		{"permanent errors don't count", []error{errNotFound, errNotFound, errNotFound}, false, CircuitClosed},
+		{"probe succeeds", []error{errUnavailable, errUnavailable, errUnavailable, nil}, true, CircuitClosed},
+		{"probe fails", []error{errUnavailable, errUnavailable, errUnavailable, errUnavailable}, true, CircuitOpen},
+	}
+	for _, test := range tests {
+		b := newCircuitBreaker("railsgirlssb", 3, 10*time.Millisecond)
+		for i, err := range test.outcomes {
+			if test.wait && i == len(test.outcomes)-1 {
+				time.Sleep(20 * time.Millisecond)
+			}
+			if !b.Allow(context.Background()) {
+				t.Fatalf("%s: request %d wasn't allowed in state %s", test.name, i+1, b.State())
+			}
+			b.Record(err)
+		}
+		if state := b.State(); state != test.state {
+			t.Errorf("%s: expected %s, got %s", test.name, test.state, state)
+		}
+	}
+}
+
+func TestCircuitBreakerOpen(t *testing.T) {
+	b := newCircuitBreaker("railsgirlssb", 1, 10*time.Millisecond)
+	b.Allow(context.Background())
+	b.Record(errUnavailable)
+	if b.Allow(context.Background()) {
+		t.Error("expected no requests during the cooldown")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !b.Allow(context.Background()) || b.State() != CircuitHalfOpen {
+		t.Fatalf("expected a probe after the cooldown, got state %s", b.State())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if b.Allow(ctx) {
+		t.Error("expected other requests to wait for the probe")
+	}
+}
+
+func TestCircuitBreakerWaitsForProbe(t *testing.T) {
+	for _, test := range []struct {
+		probe   error
+		allowed bool
+	}{
+		{nil, true},
+		{errUnavailable, false},
+	} {
+		b := newCircuitBreaker("railsgirlssb", 1, 10*time.Millisecond)
+		b.Allow(context.Background())
+		b.Record(errUnavailable)
+		time.Sleep(20 * time.Millisecond)
+		b.Allow(context.Background())
+
+		allowed := make(chan bool)
+		go func() { allowed <- b.Allow(context.Background()) }()
+		time.Sleep(10 * time.Millisecond)
+		b.Record(test.probe)
+		if result := <-allowed; result != test.allowed {
+			t.Errorf("probe %v: expected waiting requests to be allowed %t, got %t", test.probe, test.allowed, result)
+		}
+	}
+}
+
+func TestRefreshWhileHalfOpen(t *testing.T) {
+	fake, _ := setupFake(t, WufooConfig{
+		Accounts:         []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants", "coaches"}}},
+		Concurrency:      4,
+		Retry:            RetryPolicy{MaxAttempts: 1},
+		BreakerThreshold: 1,
+		BreakerCooldown:  10 * time.Millisecond,
+	})
+	fake.SetCount("applicants", 30)
+	fake.SetCount("coaches", 5)
+	setupBreakers(wufooConfig)
+
+	breakers["railsgirlssb"].Allow(context.Background())
+	breakers["railsgirlssb"].Record(errUnavailable)
+	time.Sleep(20 * time.Millisecond)
+	// A fetch abandoned during the probe must not keep the circuit stuck.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fetchForm(cancelled, wufooConfig.fetchedForms()[0]); err == nil {
+		t.Error("expected the cancelled probe to fail")
+	}
+	counts, err := count(context.Background())
+	if err != nil || counts[0] != 30 || counts[1] != 5 {
+		t.Errorf("expected all forms to be fetched after the probe, got %v %v", counts, err)
+	}
+	if state := breakers["railsgirlssb"].State(); state != CircuitClosed {
+		t.Errorf("expected the circuit to be closed, got %s", state)
+	}
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	b := newCircuitBreaker("railsgirlssb", 1, 10*time.Millisecond)
+	b.Allow(context.Background())
+	b.Record(errUnavailable)
+	time.Sleep(20 * time.Millisecond)
+	b.Allow(context.Background())
+
+	b.Record(context.Canceled)
+	if state := b.State(); state != CircuitHalfOpen {
+		t.Errorf("expected a cancelled probe to leave the circuit half-open, got %s", state)
+	}
+	if !b.Allow(context.Background()) {
+		t.Fatal("expected the next request to probe")
+	}
+	b.Record(nil)
+	if state := b.State(); state != CircuitClosed {
+		t.Errorf("expected the second probe to close the circuit, got %s", state)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// FormCount is the last known state of a single form.
+type FormCount struct {
+	Account    string    `json:"account"`
+	FormId     string    `json:"form_id"`
+	Counter    string    `json:"counter,omitempty"`
+	EntryCount int       `json:"count"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	Error      string    `json:"error,omitempty"`
+	*Capacity
+}
+
+// countCache keeps the last fetched counts in memory. Once they are older
+// than ttl they are still served while a single background fetch refreshes
+// them. After a failed fetch the next one is started no sooner than retry.
+type countCache struct {
+	sync.RWMutex
+
+	ttl   time.Duration
+	retry time.Duration
+	fetch func(context.Context) ([]int, error)
+
+	forms     []FormCount
+	err       error
+	fetchedAt time.Time
+	failedAt  time.Time
+	hasValue  bool
+	pending   *pendingRefresh
+	// pushed holds the entries added by webhooks since the last refresh.
+	pushed map[formKey]map[string]bool
+}
+
+// pendingRefresh is a running fetch. Unless it is detached it is cancelled
+// as soon as every request waiting for it has gone away.
+type pendingRefresh struct {
+	done     chan struct{}
+	cancel   context.CancelFunc
+	waiters  int
+	detached bool
+}
+
+// newCountCache creates a cache for refs. fetch must return one count per
+// form in the same order.
+func newCountCache(ttl, retry time.Duration, refs []formRef, fetch func(context.Context) ([]int, error)) *countCache {
+	forms := make([]FormCount, len(refs))
+	for i, ref := range refs {
+		forms[i].Account = ref.Account.Account
+		forms[i].FormId = ref.FormId
+		if ref.Counter != nil {
+			forms[i].Counter = ref.Counter.Name
+		}
+	}
+	return &countCache{ttl: ttl, retry: retry, fetch: fetch, forms: forms}
+}
+
+// formSelector picks the forms a total is computed over. A nil selector
+// picks all forms, without the filtered counts of counters.
+type formSelector func(FormCount) bool
+
+func accountSelector(account string) formSelector {
+	return func(form FormCount) bool {
+		return len(form.Counter) < 1 && form.Account == account
+	}
+}
+
+// Get returns the cached total of the forms picked by selector.
+func (c *countCache) Get(ctx context.Context, selector formSelector) (int, error) {
+	forms, err := c.Forms(ctx, selector)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, form := range forms {
+		count += form.EntryCount
+	}
+	return count, nil
+}
+
+// Forms returns a copy of the cached state of the forms picked by selector.
+// Only calls made before the first successful fetch block on Wufoo, until
+// the fetch is done or ctx is, and return its error; afterwards stale values
+// are returned immediately and a refresh is started in the background.
+// Within retry of a failed fetch no new one is started, and its error or
+// the stale values are returned instead.
+func (c *countCache) Forms(ctx context.Context, selector formSelector) ([]FormCount, error) {
+	c.RLock()
+	hasValue, stale := c.hasValue, time.Since(c.fetchedAt) > c.ttl
+	throttled := c.err != nil && time.Since(c.failedAt) < c.retry
+	c.RUnlock()
+
+	if !hasValue {
+		appMetrics.observeCache("miss")
+		if !throttled {
+			if err := c.wait(ctx, c.refresh(false)); err != nil {
+				return nil, err
+			}
+		}
+	} else if stale {
+		appMetrics.observeCache("stale")
+		if !throttled {
+			c.refresh(true)
+		}
+	} else {
+		appMetrics.observeCache("hit")
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	forms := []FormCount{}
+	for _, form := range c.forms {
+		if selector == nil && len(form.Counter) < 1 || selector != nil && selector(form) {
+			forms = append(forms, form)
+		}
+	}
+	if !c.hasValue {
+		return forms, c.err
+	}
+	return forms, nil
+}
+
+// restore serves the counts of snap until the first refresh, if it
+// covers every form. They are stale from the start: the cache keeps no
+// fetch time of its own, so the first request refreshes them and readiness
+// waits for this instance to reach Wufoo. Each form keeps the time it was
+// fetched at.
+func (c *countCache) restore(snap snapshot) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	restored := make([]FormCount, len(c.forms))
+	for i, form := range c.forms {
+		found := false
+		for _, saved := range snap.Forms {
+			if saved.Account == form.Account && saved.FormId == form.FormId && saved.Counter == form.Counter {
+				restored[i], found = saved, true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	c.forms, c.fetchedAt, c.hasValue = restored, time.Time{}, true
+	return true
+}
+
+// push adds a new entry reported by a webhook to the form and to the
+// filtered counts of counters for which matches is true. It returns the new
+// count of the form and "counted", or "not_cached" if nothing has been
+// fetched yet and "duplicate" if the entry was counted before. The next
+// refresh replaces the pushed counts.
+func (c *countCache) push(key formKey, entryId string, matches func(counter string) bool) (int, string) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.hasValue {
+		return 0, "not_cached"
+	}
+	if c.pushed[key][entryId] {
+		return 0, "duplicate"
+	}
+	if c.pushed == nil {
+		c.pushed = map[formKey]map[string]bool{}
+	}
+	if c.pushed[key] == nil {
+		c.pushed[key] = map[string]bool{}
+	}
+	if len(entryId) > 0 {
+		c.pushed[key][entryId] = true
+	}
+
+	before := make([]FormCount, len(c.forms))
+	copy(before, c.forms)
+	count := 0
+	for i, form := range c.forms {
+		if form.Account != key.Account || form.FormId != key.FormId {
+			continue
+		}
+		if len(form.Counter) < 1 {
+			c.forms[i].EntryCount++
+			count = c.forms[i].EntryCount
+		} else if matches(form.Counter) {
+			c.forms[i].EntryCount++
+		}
+	}
+	if event, ok := changes(before, c.forms); ok {
+		countChanges.publish(event)
+	}
+	return count, "counted"
+}
+
+// Snapshot returns the cached state of all forms without triggering a refresh.
+func (c *countCache) Snapshot() []FormCount {
+	c.RLock()
+	defer c.RUnlock()
+	forms := make([]FormCount, len(c.forms))
+	copy(forms, c.forms)
+	return forms
+}
+
+// Stale reports whether the cached counts are older than the TTL.
+func (c *countCache) Stale() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Since(c.fetchedAt) > c.ttl
+}
+
+// LastSuccess returns when all forms were last fetched successfully and the
+// error of the latest refresh, if it failed.
+func (c *countCache) LastSuccess() (time.Time, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.fetchedAt, c.err
+}
+
+// refresh starts a fetch unless one is already running. A detached fetch
+// runs to completion even if nobody waits for it.
+func (c *countCache) refresh(detached bool) *pendingRefresh {
+	c.Lock()
+	defer c.Unlock()
+	if c.pending != nil {
+		c.pending.detached = c.pending.detached || detached
+		return c.pending
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pendingRefresh{done: make(chan struct{}), cancel: cancel, detached: detached}
+	c.pending = p
+	go func() {
+		counts, err := c.fetch(ctx)
+		now, abandoned := time.Now(), ctx.Err() != nil
+		cancel()
+
+		// fetched is recorded in the history once the lock is released, so
+		// that saving it doesn't hold up requests.
+		var fetched []FormCount
+		c.Lock()
+		switch {
+		case abandoned:
+			log.Printf("refreshing counts abandoned, all waiting requests have gone away")
+		case err != nil:
+			c.err, c.failedAt = err, now
+			log.Printf("refreshing counts failed: %s", err)
+			if we, ok := err.(*wufoo.Error); ok {
+				for i := range c.forms {
+					if c.forms[i].Account == we.Account && c.forms[i].FormId == we.FormId {
+						c.forms[i].Error = we.Code
+					}
+				}
+			}
+		default:
+			c.err = nil
+			before := make([]FormCount, len(c.forms))
+			copy(before, c.forms)
+			for i := range c.forms {
+				if key := (formKey{c.forms[i].Account, c.forms[i].FormId}); len(c.forms[i].Counter) < 1 && len(c.pushed[key]) > 0 && c.forms[i].EntryCount != counts[i] {
+					log.Printf("%s form %s: correcting pushed count %d to %d", key.Account, key.FormId, c.forms[i].EntryCount, counts[i])
+				}
+				c.forms[i].EntryCount = counts[i]
+				c.forms[i].FetchedAt = now
+				c.forms[i].Error = ""
+			}
+			c.fetchedAt = now
+			c.hasValue = true
+			c.pushed = nil
+			fetched = make([]FormCount, len(c.forms))
+			copy(fetched, c.forms)
+			if event, ok := changes(before, c.forms); ok {
+				countChanges.publish(event)
+			}
+		}
+		c.pending = nil
+		c.Unlock()
+		if fetched != nil {
+			appHistory.record(now, fetched)
+		}
+		close(p.done)
+	}()
+	return p
+}
+
+// wait blocks until p has finished or ctx is done. The last request to give
+// up on a fetch that isn't detached cancels it.
+func (c *countCache) wait(ctx context.Context, p *pendingRefresh) error {
+	c.Lock()
+	p.waiters++
+	c.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		c.Lock()
+		p.waiters--
+		if p.waiters == 0 && !p.detached {
+			p.cancel()
+		}
+		c.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Run refreshes the cache every interval until the process exits.
+func (c *countCache) Run(interval time.Duration) {
+	for {
+		<-c.refresh(true).done
+		time.Sleep(interval)
+	}
+}
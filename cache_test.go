@@ -0,0 +1,167 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// settle waits for the refresh running in cache, if there is one.
+func settle(cache *countCache) {
+	cache.RLock()
+	p := cache.pending
+	cache.RUnlock()
+	if p != nil {
+		<-p.done
+	}
+}
+
+// calls returns how often fake was asked for formId.
+func calls(fake *wufoo.Fake, formId string) int {
+	fake.Lock()
+	defer fake.Unlock()
+	return fake.Calls[formId]
+}
+
+func TestFailedRefreshIsThrottled(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts:        []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		CacheTTL:        time.Millisecond,
+		RefreshInterval: time.Minute,
+	})
+	authFailed := &wufoo.Error{Code: wufoo.ErrAuthFailed, Account: "railsgirlssb", FormId: "applicants", Err: errors.New("unexpected status 401")}
+	fake.SetError("applicants", authFailed)
+
+	for i := 0; i < 20; i++ {
+		if status, body := get(t, cache, "/"); status != 401 || body["code"] != wufoo.ErrAuthFailed {
+			t.Fatalf("expected the error of the failed fetch, got %d %v", status, body)
+		}
+	}
+	if n := calls(fake, "applicants"); n != 1 {
+		t.Errorf("expected a single call to Wufoo before the first success, got %d", n)
+	}
+
+	fake.SetError("applicants", nil)
+	fake.SetCount("applicants", 30)
+	cache.Lock()
+	cache.failedAt = time.Now().Add(-time.Hour)
+	cache.Unlock()
+	get(t, cache, "/")
+
+	fake.SetError("applicants", authFailed)
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		if status, body := get(t, cache, "/"); status != 200 || body["count"] != 30.0 {
+			t.Fatalf("expected the stale count, got %d %v", status, body)
+		}
+		settle(cache)
+	}
+	if n := calls(fake, "applicants"); n != 3 {
+		t.Errorf("expected a single call to Wufoo after the counts went stale, got %d", n-2)
+	}
+}
+
+func TestStaleCountsAreServedWhileRefreshing(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		CacheTTL: 50 * time.Millisecond,
+	})
+	fake.SetCount("applicants", 30)
+	get(t, cache, "/")
+
+	fake.SetCount("applicants", 31)
+	if _, body := get(t, cache, "/"); body["count"] != 30.0 || body["stale"] != false || calls(fake, "applicants") != 1 {
+		t.Errorf("expected a fresh hit without calling Wufoo, got %v", body)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, body := get(t, cache, "/"); body["count"] != 30.0 || body["stale"] != true {
+		t.Errorf("expected the stale count while refreshing, got %v", body)
+	}
+	settle(cache)
+	if _, body := get(t, cache, "/"); body["count"] != 31.0 || body["stale"] != false {
+		t.Errorf("expected the refreshed count, got %v", body)
+	}
+}
+
+// blockingFetch returns a fetch that counts its calls and blocks until
+// release is closed or its context is done, which it reports on cancelled.
+func blockingFetch() (fetch func(context.Context) ([]int, error), calls *int, release, cancelled chan struct{}) {
+	var mu sync.Mutex
+	n := 0
+	release, cancelled = make(chan struct{}), make(chan struct{}, 1)
+	fetch = func(ctx context.Context) ([]int, error) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+		select {
+		case <-release:
+			return []int{30}, nil
+		case <-ctx.Done():
+			cancelled <- struct{}{}
+			return nil, ctx.Err()
+		}
+	}
+	return fetch, &n, release, cancelled
+}
+
+func TestConcurrentMissesShareOneFetch(t *testing.T) {
+	fetch, n, release, _ := blockingFetch()
+	cache := newCountCache(time.Minute, time.Minute, []formRef{{Account: AccountConfig{Account: "railsgirlssb"}, FormId: "applicants"}}, fetch)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if count, err := cache.Get(context.Background(), nil); err != nil || count != 30 {
+				t.Errorf("expected 30, got %d %v", count, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if *n != 1 {
+		t.Errorf("expected one fetch for all waiting requests, got %d", *n)
+	}
+}
+
+func TestFetchIsCancelledWhenAllWaitersLeave(t *testing.T) {
+	fetch, _, release, cancelled := blockingFetch()
+	defer close(release)
+	cache := newCountCache(time.Minute, time.Minute, []formRef{{Account: AccountConfig{Account: "railsgirlssb"}, FormId: "applicants"}}, fetch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	other, cancelOther := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, err := cache.Get(ctx, nil); errs <- err }()
+	go func() { _, err := cache.Get(other, nil); errs <- err }()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("expected the request to give up, got %v", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("expected the fetch to go on while a request still waits for it")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancelOther()
+	<-errs
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the fetch to be cancelled once the last request gave up")
+	}
+	settle(cache)
+	if _, err := cache.LastSuccess(); err != nil {
+		t.Errorf("expected an abandoned fetch not to be recorded as failed, got %v", err)
+	}
+}
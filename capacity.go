@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+)
+
+// Capacity describes how full a total is compared to the seats available.
+type Capacity struct {
+	Capacity    int     `json:"capacity"`
+	Remaining   int     `json:"remaining"`
+	PercentFull float64 `json:"percent_full"`
+	Full        bool    `json:"full"`
+}
+
+// newCapacity returns nil when no capacity is configured.
+func newCapacity(count, capacity int) *Capacity {
+	if capacity <= 0 {
+		return nil
+	}
+	remaining := capacity - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &Capacity{
+		Capacity:    capacity,
+		Remaining:   remaining,
+		PercentFull: math.Round(float64(count)*1000/float64(capacity)) / 10,
+		Full:        remaining == 0,
+	}
+}
+
+// addTo merges the capacity fields into a JSON response body.
+func (c *Capacity) addTo(body map[string]interface{}) map[string]interface{} {
+	if c != nil {
+		body["capacity"] = c.Capacity
+		body["remaining"] = c.Remaining
+		body["percent_full"] = c.PercentFull
+		body["full"] = c.Full
+	}
+	return body
+}
+
+// formCapacity returns the capacity configured for a single form, or 0.
+func (c WufooConfig) formCapacity(account, formId string) int {
+	a, _ := c.Account(account)
+	return a.Capacities[formId]
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type WufooConfig struct {
+	Accounts         []AccountConfig
+	Counters         []CounterConfig
+	Capacity         int
+	CacheTTL         time.Duration
+	RefreshInterval  time.Duration
+	ReadyThreshold   time.Duration
+	HistoryRetention time.Duration
+	Storage          string
+	StoragePath      string
+	WebhookKey       string
+	WebSocketLimit   int
+	Concurrency      int
+	Retry            RetryPolicy
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	HTTP             HTTPConfig
+}
+
+// AccountConfig holds the credentials and forms of one Wufoo account.
+type AccountConfig struct {
+	Account    string
+	ApiKey     string
+	Password   string
+	FormIds    []string
+	Capacities map[string]int
+}
+
+// HTTPConfig configures the connection to Wufoo.
+type HTTPConfig struct {
+	Scheme             string
+	Host               string
+	APIVersion         string
+	Timeout            time.Duration
+	UserAgent          string
+	Proxy              string
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// accountPlaceholder is replaced by the account name in HTTPConfig.Host.
+const accountPlaceholder = "{account}"
+
+// BaseURL is the root of the Wufoo API of account, like
+// https://railsgirlssb.wufoo.com/api/v3.
+func (h HTTPConfig) BaseURL(account string) string {
+	host := strings.Replace(h.Host, accountPlaceholder, account, -1)
+	return fmt.Sprintf("%s://%s/api/%s", h.Scheme, strings.TrimSuffix(host, "/"), h.APIVersion)
+}
+
+// formRef identifies a form within one of the configured accounts. Counter
+// is set when only the entries matching the filter of a counter are counted.
+type formRef struct {
+	Account AccountConfig
+	FormId  string
+	Counter *CounterConfig
+}
+
+// Forms lists the forms of all accounts in configuration order.
+func (c WufooConfig) Forms() []formRef {
+	var forms []formRef
+	for _, account := range c.Accounts {
+		for _, formId := range account.FormIds {
+			forms = append(forms, formRef{Account: account, FormId: formId})
+		}
+	}
+	return forms
+}
+
+// Account looks up an account by name.
+func (c WufooConfig) Account(name string) (AccountConfig, bool) {
+	for _, account := range c.Accounts {
+		if account.Account == name {
+			return account, true
+		}
+	}
+	return AccountConfig{}, false
+}
+
+// configFile is the JSON layout of the file named by WUFOO_CONFIG.
+// Durations are written like "1m" or "30s". The top level account fields
+// describe a single account and may be combined with the accounts list.
+type configFile struct {
+	accountFile
+	Accounts         []accountFile   `json:"accounts"`
+	Counters         []CounterConfig `json:"counters"`
+	Capacity         int             `json:"capacity"`
+	CacheTTL         string          `json:"cache_ttl"`
+	RefreshInterval  string          `json:"refresh_interval"`
+	ReadyThreshold   string          `json:"ready_threshold"`
+	HistoryRetention string          `json:"history_retention"`
+	WebhookKey       string          `json:"webhook_key"`
+	WebSocketLimit   int             `json:"websocket_limit"`
+	Storage          struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+	} `json:"storage"`
+	Concurrency int `json:"concurrency"`
+	Retry       struct {
+		MaxAttempts int      `json:"max_attempts"`
+		BaseDelay   string   `json:"base_delay"`
+		MaxDelay    string   `json:"max_delay"`
+		Jitter      *float64 `json:"jitter"`
+	} `json:"retry"`
+	Breaker struct {
+		Threshold int    `json:"threshold"`
+		Cooldown  string `json:"cooldown"`
+	} `json:"breaker"`
+	HTTP struct {
+		Scheme             string `json:"scheme"`
+		Host               string `json:"host"`
+		APIVersion         string `json:"api_version"`
+		Timeout            string `json:"timeout"`
+		UserAgent          string `json:"user_agent"`
+		Proxy              string `json:"proxy"`
+		CAFile             string `json:"ca_file"`
+		InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	} `json:"http"`
+}
+
+type accountFile struct {
+	Account    string         `json:"account"`
+	ApiKey     string         `json:"api_key"`
+	Password   string         `json:"password"`
+	FormIds    []string       `json:"form_ids"`
+	Capacities map[string]int `json:"capacities"`
+}
+
+var (
+	accountPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	apiKeyPattern  = regexp.MustCompile(`^[A-Za-z0-9]{4}(-[A-Za-z0-9]{4}){3}$`)
+	versionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// loadConfig builds the configuration from the defaults, the optional config
+// file and the environment, in that order of precedence, and validates it.
+func loadConfig() (WufooConfig, error) {
+	config := WufooConfig{
+		CacheTTL:         time.Minute,
+		Concurrency:      4,
+		HistoryRetention: 30 * 24 * time.Hour,
+		WebSocketLimit:   100,
+		Storage:          "memory",
+		StoragePath:      "counts.db",
+		Retry: RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   200 * time.Millisecond,
+			MaxDelay:    5 * time.Second,
+			Jitter:      0.5,
+		},
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+		HTTP: HTTPConfig{
+			Scheme:     "https",
+			Host:       accountPlaceholder + ".wufoo.com",
+			APIVersion: "v3",
+			Timeout:    10 * time.Second,
+			UserAgent:  "wufoo-count-app (+https://github.com/railsgirlssb/wufoo-count-app)",
+		},
+	}
+
+	if path := os.Getenv("WUFOO_CONFIG"); len(path) > 0 {
+		if err := config.loadFile(path); err != nil {
+			return config, err
+		}
+	}
+	if err := config.loadEnv(); err != nil {
+		return config, err
+	}
+	if config.RefreshInterval == 0 {
+		config.RefreshInterval = config.CacheTTL
+	}
+	if config.ReadyThreshold == 0 {
+		config.ReadyThreshold = 3 * config.RefreshInterval
+	}
+	for i := range config.Accounts {
+		if len(config.Accounts[i].Password) < 1 {
+			config.Accounts[i].Password = "any"
+		}
+	}
+
+	return config, config.Validate()
+}
+
+func (c *WufooConfig) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %s", err)
+	}
+
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parsing config file %s: %s", path, err)
+	}
+
+	for _, account := range append([]accountFile{file.accountFile}, file.Accounts...) {
+		if len(account.Account) > 0 || len(account.ApiKey) > 0 || account.FormIds != nil {
+			c.Accounts = append(c.Accounts, AccountConfig(account))
+		}
+	}
+	if file.Counters != nil {
+		c.Counters = file.Counters
+	}
+	if file.Capacity != 0 {
+		c.Capacity = file.Capacity
+	}
+	if file.Concurrency != 0 {
+		c.Concurrency = file.Concurrency
+	}
+	if err := parseDuration(path+": cache_ttl", file.CacheTTL, &c.CacheTTL); err != nil {
+		return err
+	}
+	if err := parseDuration(path+": refresh_interval", file.RefreshInterval, &c.RefreshInterval); err != nil {
+		return err
+	}
+	if file.Retry.MaxAttempts != 0 {
+		c.Retry.MaxAttempts = file.Retry.MaxAttempts
+	}
+	if file.Retry.Jitter != nil {
+		c.Retry.Jitter = *file.Retry.Jitter
+	}
+	if err := parseDuration(path+": retry.base_delay", file.Retry.BaseDelay, &c.Retry.BaseDelay); err != nil {
+		return err
+	}
+	if err := parseDuration(path+": retry.max_delay", file.Retry.MaxDelay, &c.Retry.MaxDelay); err != nil {
+		return err
+	}
+	if file.Breaker.Threshold != 0 {
+		c.BreakerThreshold = file.Breaker.Threshold
+	}
+	if err := parseDuration(path+": breaker.cooldown", file.Breaker.Cooldown, &c.BreakerCooldown); err != nil {
+		return err
+	}
+	if len(file.HTTP.Scheme) > 0 {
+		c.HTTP.Scheme = file.HTTP.Scheme
+	}
+	if len(file.HTTP.Host) > 0 {
+		c.HTTP.Host = file.HTTP.Host
+	}
+	if len(file.HTTP.APIVersion) > 0 {
+		c.HTTP.APIVersion = file.HTTP.APIVersion
+	}
+	if len(file.HTTP.UserAgent) > 0 {
+		c.HTTP.UserAgent = file.HTTP.UserAgent
+	}
+	if len(file.HTTP.Proxy) > 0 {
+		c.HTTP.Proxy = file.HTTP.Proxy
+	}
+	if len(file.HTTP.CAFile) > 0 {
+		c.HTTP.CAFile = file.HTTP.CAFile
+	}
+	c.HTTP.InsecureSkipVerify = file.HTTP.InsecureSkipVerify
+	if err := parseDuration(path+": http.timeout", file.HTTP.Timeout, &c.HTTP.Timeout); err != nil {
+		return err
+	}
+	if file.WebSocketLimit != 0 {
+		c.WebSocketLimit = file.WebSocketLimit
+	}
+	if len(file.WebhookKey) > 0 {
+		c.WebhookKey = file.WebhookKey
+	}
+	if len(file.Storage.Type) > 0 {
+		c.Storage = file.Storage.Type
+	}
+	if len(file.Storage.Path) > 0 {
+		c.StoragePath = file.Storage.Path
+	}
+	if err := parseDuration(path+": history_retention", file.HistoryRetention, &c.HistoryRetention); err != nil {
+		return err
+	}
+	return parseDuration(path+": ready_threshold", file.ReadyThreshold, &c.ReadyThreshold)
+}
+
+// loadEnv applies the environment. WUFOO_ACCOUNT, WUFOO_API_KEY and
+// WUFOO_FORM_IDS override the account of the same name from the config file,
+// or its only account, and otherwise add a new one.
+func (c *WufooConfig) loadEnv() error {
+	name, apiKey, formIds := os.Getenv("WUFOO_ACCOUNT"), os.Getenv("WUFOO_API_KEY"), os.Getenv("WUFOO_FORM_IDS")
+	if len(name) > 0 || len(apiKey) > 0 || len(formIds) > 0 {
+		account := c.envAccount(name)
+		if len(name) > 0 {
+			account.Account = name
+		}
+		if len(apiKey) > 0 {
+			account.ApiKey = apiKey
+		}
+		if len(formIds) > 0 {
+			account.FormIds = strings.Split(formIds, ",")
+			for i := range account.FormIds {
+				account.FormIds[i] = strings.TrimSpace(account.FormIds[i])
+			}
+		}
+	}
+
+	if value := os.Getenv("WUFOO_COUNTERS"); len(value) > 0 {
+		counters, err := parseCounters(value)
+		if err != nil {
+			return fmt.Errorf("WUFOO_COUNTERS: %s", err)
+		}
+		c.Counters = counters
+	}
+	if value := os.Getenv("WUFOO_CAPACITY"); len(value) > 0 {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("WUFOO_CAPACITY: %q is not a number", value)
+		}
+		c.Capacity = i
+	}
+	if value := os.Getenv("WUFOO_CONCURRENCY"); len(value) > 0 {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("WUFOO_CONCURRENCY: %q is not a number", value)
+		}
+		c.Concurrency = i
+	}
+	if err := parseDuration("WUFOO_CACHE_TTL", os.Getenv("WUFOO_CACHE_TTL"), &c.CacheTTL); err != nil {
+		return err
+	}
+	if err := parseDuration("WUFOO_REFRESH_INTERVAL", os.Getenv("WUFOO_REFRESH_INTERVAL"), &c.RefreshInterval); err != nil {
+		return err
+	}
+	if value := os.Getenv("WUFOO_RETRY_ATTEMPTS"); len(value) > 0 {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("WUFOO_RETRY_ATTEMPTS: %q is not a number", value)
+		}
+		c.Retry.MaxAttempts = i
+	}
+	if value := os.Getenv("WUFOO_RETRY_JITTER"); len(value) > 0 {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("WUFOO_RETRY_JITTER: %q is not a number", value)
+		}
+		c.Retry.Jitter = f
+	}
+	if err := parseDuration("WUFOO_RETRY_BASE_DELAY", os.Getenv("WUFOO_RETRY_BASE_DELAY"), &c.Retry.BaseDelay); err != nil {
+		return err
+	}
+	if err := parseDuration("WUFOO_RETRY_MAX_DELAY", os.Getenv("WUFOO_RETRY_MAX_DELAY"), &c.Retry.MaxDelay); err != nil {
+		return err
+	}
+	if value := os.Getenv("WUFOO_BREAKER_THRESHOLD"); len(value) > 0 {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("WUFOO_BREAKER_THRESHOLD: %q is not a number", value)
+		}
+		c.BreakerThreshold = i
+	}
+	if err := parseDuration("WUFOO_BREAKER_COOLDOWN", os.Getenv("WUFOO_BREAKER_COOLDOWN"), &c.BreakerCooldown); err != nil {
+		return err
+	}
+	if value := os.Getenv("WUFOO_SCHEME"); len(value) > 0 {
+		c.HTTP.Scheme = value
+	}
+	if value := os.Getenv("WUFOO_HOST"); len(value) > 0 {
+		c.HTTP.Host = value
+	}
+	if value := os.Getenv("WUFOO_API_VERSION"); len(value) > 0 {
+		c.HTTP.APIVersion = value
+	}
+	if value := os.Getenv("WUFOO_USER_AGENT"); len(value) > 0 {
+		c.HTTP.UserAgent = value
+	}
+	if value := os.Getenv("WUFOO_PROXY"); len(value) > 0 {
+		c.HTTP.Proxy = value
+	}
+	if value := os.Getenv("WUFOO_CA_FILE"); len(value) > 0 {
+		c.HTTP.CAFile = value
+	}
+	if value := os.Getenv("WUFOO_INSECURE_SKIP_VERIFY"); len(value) > 0 {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("WUFOO_INSECURE_SKIP_VERIFY: %q is not true or false", value)
+		}
+		c.HTTP.InsecureSkipVerify = b
+	}
+	if err := parseDuration("WUFOO_TIMEOUT", os.Getenv("WUFOO_TIMEOUT"), &c.HTTP.Timeout); err != nil {
+		return err
+	}
+	if value := os.Getenv("WUFOO_WEBSOCKET_LIMIT"); len(value) > 0 {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("WUFOO_WEBSOCKET_LIMIT: %q is not a number", value)
+		}
+		c.WebSocketLimit = i
+	}
+	if value := os.Getenv("WUFOO_WEBHOOK_KEY"); len(value) > 0 {
+		c.WebhookKey = value
+	}
+	if value := os.Getenv("WUFOO_STORAGE"); len(value) > 0 {
+		c.Storage = value
+	}
+	if value := os.Getenv("WUFOO_STORAGE_PATH"); len(value) > 0 {
+		c.StoragePath = value
+	}
+	if err := parseDuration("WUFOO_HISTORY_RETENTION", os.Getenv("WUFOO_HISTORY_RETENTION"), &c.HistoryRetention); err != nil {
+		return err
+	}
+	return parseDuration("WUFOO_READY_THRESHOLD", os.Getenv("WUFOO_READY_THRESHOLD"), &c.ReadyThreshold)
+}
+
+func (c *WufooConfig) envAccount(name string) *AccountConfig {
+	for i := range c.Accounts {
+		if c.Accounts[i].Account == name {
+			return &c.Accounts[i]
+		}
+	}
+	if len(name) < 1 && len(c.Accounts) == 1 {
+		return &c.Accounts[0]
+	}
+	c.Accounts = append(c.Accounts, AccountConfig{})
+	return &c.Accounts[len(c.Accounts)-1]
+}
+
+// parseDuration sets d from value unless value is empty.
+func parseDuration(name, value string, d *time.Duration) error {
+	if len(value) < 1 {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a duration like 30s or 5m", name, value)
+	}
+	*d = parsed
+	return nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c WufooConfig) Validate() error {
+	var problems []string
+
+	if len(c.Accounts) < 1 {
+		problems = append(problems, "no account configured, set WUFOO_ACCOUNT")
+	}
+	accounts := map[string]bool{}
+	for i, account := range c.Accounts {
+		problems = append(problems, account.validate(i)...)
+		if accounts[account.Account] {
+			problems = append(problems, fmt.Sprintf("account %q is listed more than once", account.Account))
+		}
+		accounts[account.Account] = true
+	}
+	problems = append(problems, c.validateCounters()...)
+	if c.Capacity < 0 {
+		problems = append(problems, "capacity must not be negative")
+	}
+
+	if c.CacheTTL <= 0 {
+		problems = append(problems, "cache TTL must be positive")
+	}
+	if c.RefreshInterval <= 0 {
+		problems = append(problems, "refresh interval must be positive")
+	}
+	if c.ReadyThreshold <= 0 {
+		problems = append(problems, "ready threshold must be positive")
+	}
+	if c.HistoryRetention <= 0 {
+		problems = append(problems, "history retention must be positive")
+	}
+	if c.WebSocketLimit < 1 {
+		problems = append(problems, "websocket limit must be at least 1")
+	}
+	if c.Storage != "memory" && c.Storage != "bolt" {
+		problems = append(problems, fmt.Sprintf("storage %q must be memory or bolt", c.Storage))
+	}
+	if c.Storage == "bolt" && len(c.StoragePath) < 1 {
+		problems = append(problems, "storage path is empty, set WUFOO_STORAGE_PATH")
+	}
+	if c.Concurrency < 1 {
+		problems = append(problems, "concurrency must be at least 1")
+	}
+	if c.Retry.MaxAttempts < 1 {
+		problems = append(problems, "retry attempts must be at least 1")
+	}
+	if c.Retry.BaseDelay <= 0 || c.Retry.MaxDelay < c.Retry.BaseDelay {
+		problems = append(problems, "retry delays must be positive, with the maximum not below the base delay")
+	}
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		problems = append(problems, "retry jitter must be between 0 and 1")
+	}
+	if c.BreakerThreshold < 1 {
+		problems = append(problems, "breaker threshold must be at least 1")
+	}
+	if c.BreakerCooldown <= 0 {
+		problems = append(problems, "breaker cooldown must be positive")
+	}
+	if c.HTTP.Scheme != "https" && c.HTTP.Scheme != "http" {
+		problems = append(problems, fmt.Sprintf("scheme %q must be https or http", c.HTTP.Scheme))
+	}
+	if u, err := url.Parse(c.HTTP.BaseURL("account")); len(c.HTTP.Host) < 1 || err != nil || len(u.Host) < 1 {
+		problems = append(problems, fmt.Sprintf("host %q is not a host name like %s.wufoo.com", c.HTTP.Host, accountPlaceholder))
+	}
+	if !versionPattern.MatchString(c.HTTP.APIVersion) {
+		problems = append(problems, fmt.Sprintf("API version %q must be written like v3", c.HTTP.APIVersion))
+	}
+	if c.HTTP.Timeout <= 0 {
+		problems = append(problems, "HTTP timeout must be positive")
+	}
+	if len(c.HTTP.Proxy) > 0 {
+		if u, err := url.Parse(c.HTTP.Proxy); err != nil || len(u.Scheme) < 1 || len(u.Host) < 1 {
+			problems = append(problems, fmt.Sprintf("proxy %q is not a URL like http://proxy:8888", c.HTTP.Proxy))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (a AccountConfig) validate(index int) []string {
+	var problems []string
+
+	name := fmt.Sprintf("account %q", a.Account)
+	if len(a.Account) < 1 {
+		name = fmt.Sprintf("account #%d", index+1)
+		problems = append(problems, name+": name is empty, set WUFOO_ACCOUNT")
+	} else if !accountPattern.MatchString(a.Account) {
+		problems = append(problems, name+": name may only contain letters, digits and dashes")
+	}
+
+	if len(a.ApiKey) < 1 {
+		problems = append(problems, name+": API key is empty, set WUFOO_API_KEY")
+	} else if !apiKeyPattern.MatchString(a.ApiKey) {
+		problems = append(problems, name+": API key is malformed, expected XXXX-XXXX-XXXX-XXXX")
+	}
+
+	if len(a.FormIds) < 1 {
+		problems = append(problems, name+": no form IDs configured, set WUFOO_FORM_IDS")
+	}
+	seen := map[string]bool{}
+	for i, formId := range a.FormIds {
+		switch {
+		case len(strings.TrimSpace(formId)) < 1:
+			problems = append(problems, fmt.Sprintf("%s: form ID #%d is empty", name, i+1))
+		case seen[formId]:
+			problems = append(problems, fmt.Sprintf("%s: form ID %q is listed more than once", name, formId))
+		}
+		seen[formId] = true
+	}
+	for formId, capacity := range a.Capacities {
+		if !seen[formId] {
+			problems = append(problems, fmt.Sprintf("%s: capacity given for unknown form ID %q", name, formId))
+		}
+		if capacity < 0 {
+			problems = append(problems, fmt.Sprintf("%s: capacity of form ID %q must not be negative", name, formId))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		http HTTPConfig
+		url  string
+	}{
+		{HTTPConfig{Scheme: "https", Host: "{account}.wufoo.com", APIVersion: "v3"}, "https://railsgirlssb.wufoo.com/api/v3"},
+		{HTTPConfig{Scheme: "http", Host: "localhost:8080/{account}/", APIVersion: "v4"}, "http://localhost:8080/railsgirlssb/api/v4"},
+	}
+	for _, test := range tests {
+		if url := test.http.BaseURL("railsgirlssb"); url != test.url {
+			t.Errorf("expected %s, got %s", test.url, url)
+		}
+	}
+}
+
+func TestCounterFilter(t *testing.T) {
+	now := time.Date(2015, 3, 8, 12, 0, 0, 0, time.UTC)
+	counter := CounterConfig{Filters: []string{"DateCreated Is_after {now-168h}", "DateCreated Is_before {now}"}}
+	filter, err := counter.filter(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.Rules[0] != "DateCreated Is_after 2015-03-01 12:00:00" || filter.Rules[1] != "DateCreated Is_before 2015-03-08 12:00:00" {
+		t.Errorf("unexpected rules %q", filter.Rules)
+	}
+
+	counter.Filters = []string{"DateCreated Is_after {now-1week}"}
+	if _, err := counter.filter(now); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+}
+
+// validConfig returns a configuration that passes Validate.
+func validConfig() WufooConfig {
+	return WufooConfig{
+		Accounts:         []AccountConfig{{Account: "railsgirlssb", ApiKey: testKey, Password: "any", FormIds: []string{"applicants", "coaches"}}},
+		Counters:         []CounterConfig{{Name: "applicants", Forms: []string{"applicants"}, Capacity: 40}},
+		CacheTTL:         time.Minute,
+		RefreshInterval:  time.Minute,
+		ReadyThreshold:   3 * time.Minute,
+		HistoryRetention: time.Hour,
+		Storage:          "memory",
+		WebSocketLimit:   100,
+		Concurrency:      4,
+		Retry:            RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second, Jitter: 0.5},
+		BreakerThreshold: 5,
+		BreakerCooldown:  time.Second,
+		HTTP:             HTTPConfig{Scheme: "https", Host: "{account}.wufoo.com", APIVersion: "v3", Timeout: time.Second},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *WufooConfig)
+		problem string
+	}{
+		{"valid", func(c *WufooConfig) {}, ""},
+		{"no account", func(c *WufooConfig) { c.Accounts = nil }, "no account configured"},
+		{"empty account name", func(c *WufooConfig) { c.Accounts[0].Account = "" }, "account #1: name is empty"},
+		{"bad account name", func(c *WufooConfig) { c.Accounts[0].Account = "rails girls" }, "name may only contain letters, digits and dashes"},
+		{"duplicate account", func(c *WufooConfig) { c.Accounts = append(c.Accounts, c.Accounts[0]) }, `account "railsgirlssb" is listed more than once`},
+		{"empty API key", func(c *WufooConfig) { c.Accounts[0].ApiKey = "" }, "API key is empty"},
+		{"short API key", func(c *WufooConfig) { c.Accounts[0].ApiKey = "ABCD-EFGH-IJKL" }, "API key is malformed"},
+		{"API key with symbols", func(c *WufooConfig) { c.Accounts[0].ApiKey = "ABCD-EFGH-IJKL-MN!P" }, "API key is malformed"},
+		{"no form IDs", func(c *WufooConfig) { c.Accounts[0].FormIds = nil; c.Counters = nil }, "no form IDs configured"},
+		{"empty form ID", func(c *WufooConfig) { c.Accounts[0].FormIds = append(c.Accounts[0].FormIds, " ") }, "form ID #3 is empty"},
+		{"duplicate form ID", func(c *WufooConfig) { c.Accounts[0].FormIds = append(c.Accounts[0].FormIds, "coaches") }, `form ID "coaches" is listed more than once`},
+		{"capacity of unknown form", func(c *WufooConfig) { c.Accounts[0].Capacities = map[string]int{"mentors": 10} }, `capacity given for unknown form ID "mentors"`},
+		{"negative form capacity", func(c *WufooConfig) { c.Accounts[0].Capacities = map[string]int{"coaches": -1} }, `capacity of form ID "coaches" must not be negative`},
+		{"bad counter name", func(c *WufooConfig) { c.Counters[0].Name = "all applicants" }, "counter #1: name may only contain"},
+		{"duplicate counter", func(c *WufooConfig) { c.Counters = append(c.Counters, c.Counters[0]) }, `counter "applicants" is listed more than once`},
+		{"counter without forms", func(c *WufooConfig) { c.Counters[0].Forms = nil }, `counter "applicants": no forms configured`},
+		{"counter of unknown form", func(c *WufooConfig) { c.Counters[0].Forms = []string{"mentors"} }, `counter "applicants": `},
+		{"negative capacity", func(c *WufooConfig) { c.Capacity = -1 }, "capacity must not be negative"},
+		{"cache TTL", func(c *WufooConfig) { c.CacheTTL = 0 }, "cache TTL must be positive"},
+		{"refresh interval", func(c *WufooConfig) { c.RefreshInterval = -time.Second }, "refresh interval must be positive"},
+		{"ready threshold", func(c *WufooConfig) { c.ReadyThreshold = 0 }, "ready threshold must be positive"},
+		{"history retention", func(c *WufooConfig) { c.HistoryRetention = 0 }, "history retention must be positive"},
+		{"websocket limit", func(c *WufooConfig) { c.WebSocketLimit = 0 }, "websocket limit must be at least 1"},
+		{"storage", func(c *WufooConfig) { c.Storage = "redis" }, `storage "redis" must be memory or bolt`},
+		{"storage path", func(c *WufooConfig) { c.Storage = "bolt" }, "storage path is empty"},
+		{"concurrency", func(c *WufooConfig) { c.Concurrency = 0 }, "concurrency must be at least 1"},
+		{"retry attempts", func(c *WufooConfig) { c.Retry.MaxAttempts = 0 }, "retry attempts must be at least 1"},
+		{"retry delays", func(c *WufooConfig) { c.Retry.MaxDelay = time.Microsecond }, "retry delays must be positive"},
+		{"retry jitter", func(c *WufooConfig) { c.Retry.Jitter = 1.5 }, "retry jitter must be between 0 and 1"},
+		{"breaker threshold", func(c *WufooConfig) { c.BreakerThreshold = 0 }, "breaker threshold must be at least 1"},
+		{"breaker cooldown", func(c *WufooConfig) { c.BreakerCooldown = 0 }, "breaker cooldown must be positive"},
+		{"scheme", func(c *WufooConfig) { c.HTTP.Scheme = "ftp" }, `scheme "ftp" must be https or http`},
+		{"host", func(c *WufooConfig) { c.HTTP.Host = "" }, `host "" is not a host name`},
+		{"API version", func(c *WufooConfig) { c.HTTP.APIVersion = "v3/../v4" }, "API version"},
+		{"timeout", func(c *WufooConfig) { c.HTTP.Timeout = 0 }, "HTTP timeout must be positive"},
+		{"proxy", func(c *WufooConfig) { c.HTTP.Proxy = "proxy:8888" }, `proxy "proxy:8888" is not a URL`},
+	}
+	for _, test := range tests {
+		config := validConfig()
+		test.change(&config)
+		err := config.Validate()
+		switch {
+		case len(test.problem) < 1 && err != nil:
+			t.Errorf("%s: expected no problems, got %s", test.name, err)
+		case len(test.problem) > 0 && (err == nil || !strings.Contains(err.Error(), test.problem)):
+			t.Errorf("%s: expected %q, got %v", test.name, test.problem, err)
+		}
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	config := validConfig()
+	config.CacheTTL, config.Concurrency = 0, 0
+	err := config.Validate()
+	if err == nil || !strings.Contains(err.Error(), "cache TTL") || !strings.Contains(err.Error(), "concurrency") {
+		t.Errorf("expected both problems, got %v", err)
+	}
+}
+
+func TestParseCounters(t *testing.T) {
+	tests := []struct {
+		value    string
+		counters []CounterConfig
+		err      bool
+	}{
+		{"applicants=f1", []CounterConfig{{Name: "applicants", Forms: []string{"f1"}}}, false},
+		{"applicants=f1,f2;coaches=f3", []CounterConfig{{Name: "applicants", Forms: []string{"f1", "f2"}}, {Name: "coaches", Forms: []string{"f3"}}}, false},
+		{" applicants : 40 = f1 , sb/f2 ;", []CounterConfig{{Name: "applicants", Capacity: 40, Forms: []string{"f1", "sb/f2"}}}, false},
+		{";;", nil, false},
+		{"applicants", nil, true},
+		{"applicants:forty=f1", nil, true},
+	}
+	for _, test := range tests {
+		counters, err := parseCounters(test.value)
+		if (err != nil) != test.err || !reflect.DeepEqual(counters, test.counters) {
+			t.Errorf("%q: expected %v (error %t), got %v %v", test.value, test.counters, test.err, counters, err)
+		}
+	}
+}
+
+// setupEnv clears the WUFOO_ variables for the test, then sets env. An
+// empty value counts as unset.
+func setupEnv(t *testing.T, env map[string]string) {
+	for _, variable := range os.Environ() {
+		if name := strings.SplitN(variable, "=", 2)[0]; strings.HasPrefix(name, "WUFOO_") {
+			t.Setenv(name, "")
+		}
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+// writeConfig writes a config file into a temporary directory and returns
+// its path.
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "wufoo.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `{
+		"account": "railsgirlssb",
+		"api_key": "ABCD-EFGH-IJKL-MNOP",
+		"form_ids": ["applicants"],
+		"cache_ttl": "5m",
+		"concurrency": 2,
+		"retry": {"max_attempts": 5},
+		"http": {"api_version": "v4", "timeout": "3s"}
+	}`)
+	setupEnv(t, map[string]string{
+		"WUFOO_CONFIG":      path,
+		"WUFOO_CACHE_TTL":   "30s",
+		"WUFOO_TIMEOUT":     "1s",
+		"WUFOO_COUNTERS":    "applicants:40=applicants",
+		"WUFOO_CONCURRENCY": "",
+	})
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"cache TTL from the environment", config.CacheTTL, 30 * time.Second},
+		{"timeout from the environment", config.HTTP.Timeout, time.Second},
+		{"concurrency from the file", config.Concurrency, 2},
+		{"retry attempts from the file", config.Retry.MaxAttempts, 5},
+		{"API version from the file", config.HTTP.APIVersion, "v4"},
+		{"retry delay by default", config.Retry.BaseDelay, 200 * time.Millisecond},
+		{"refresh interval from the cache TTL", config.RefreshInterval, 30 * time.Second},
+		{"ready threshold from the refresh interval", config.ReadyThreshold, 90 * time.Second},
+		{"password by default", config.Accounts[0].Password, "any"},
+		{"counters from the environment", config.Counters, []CounterConfig{{Name: "applicants", Capacity: 40, Forms: []string{"applicants"}}}},
+	} {
+		if !reflect.DeepEqual(test.value, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, test.value)
+		}
+	}
+}
+
+func TestLoadConfigAccounts(t *testing.T) {
+	single := `{"account": "railsgirlssb", "api_key": "ABCD-EFGH-IJKL-MNOP", "form_ids": ["applicants"]}`
+	multiple := `{"accounts": [
+		{"account": "railsgirlssb", "api_key": "ABCD-EFGH-IJKL-MNOP", "form_ids": ["applicants"]},
+		{"account": "railsgirlsberlin", "api_key": "QRST-UVWX-YZ12-3456", "form_ids": ["berlin"]}
+	]}`
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		accounts []AccountConfig
+	}{
+		{
+			"environment only", "",
+			map[string]string{"WUFOO_ACCOUNT": "railsgirlssb", "WUFOO_API_KEY": "ABCD-EFGH-IJKL-MNOP", "WUFOO_FORM_IDS": "applicants, coaches"},
+			[]AccountConfig{{Account: "railsgirlssb", ApiKey: "ABCD-EFGH-IJKL-MNOP", Password: "any", FormIds: []string{"applicants", "coaches"}}},
+		},
+		{
+			"file only", single, nil,
+			[]AccountConfig{{Account: "railsgirlssb", ApiKey: "ABCD-EFGH-IJKL-MNOP", Password: "any", FormIds: []string{"applicants"}}},
+		},
+		{
+			"unnamed overrides the only account", single,
+			map[string]string{"WUFOO_API_KEY": "ZZZZ-EFGH-IJKL-MNOP"},
+			[]AccountConfig{{Account: "railsgirlssb", ApiKey: "ZZZZ-EFGH-IJKL-MNOP", Password: "any", FormIds: []string{"applicants"}}},
+		},
+		{
+			"named overrides its account", multiple,
+			map[string]string{"WUFOO_ACCOUNT": "railsgirlsberlin", "WUFOO_FORM_IDS": "berlin,coaches"},
+			[]AccountConfig{
+				{Account: "railsgirlssb", ApiKey: "ABCD-EFGH-IJKL-MNOP", Password: "any", FormIds: []string{"applicants"}},
+				{Account: "railsgirlsberlin", ApiKey: "QRST-UVWX-YZ12-3456", Password: "any", FormIds: []string{"berlin", "coaches"}},
+			},
+		},
+		{
+			"new name is added", single,
+			map[string]string{"WUFOO_ACCOUNT": "railsgirlsberlin", "WUFOO_API_KEY": "QRST-UVWX-YZ12-3456", "WUFOO_FORM_IDS": "berlin"},
+			[]AccountConfig{
+				{Account: "railsgirlssb", ApiKey: "ABCD-EFGH-IJKL-MNOP", Password: "any", FormIds: []string{"applicants"}},
+				{Account: "railsgirlsberlin", ApiKey: "QRST-UVWX-YZ12-3456", Password: "any", FormIds: []string{"berlin"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{}
+			for name, value := range test.env {
+				env[name] = value
+			}
+			if len(test.file) > 0 {
+				env["WUFOO_CONFIG"] = writeConfig(t, test.file)
+			}
+			setupEnv(t, env)
+
+			config, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Accounts, test.accounts) {
+				t.Errorf("expected %+v, got %+v", test.accounts, config.Accounts)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	valid := `{"account": "railsgirlssb", "api_key": "ABCD-EFGH-IJKL-MNOP", "form_ids": ["applicants"]`
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		err  string
+	}{
+		{"missing file", "", map[string]string{"WUFOO_CONFIG": "/nonexistent/wufoo.json"}, "reading config file"},
+		{"invalid JSON", `{"account": `, nil, "parsing config file"},
+		{"duration in the file", valid + `, "cache_ttl": "a minute"}`, nil, `cache_ttl: "a minute" is not a duration`},
+		{"duration in the environment", valid + "}", map[string]string{"WUFOO_CACHE_TTL": "60"}, `WUFOO_CACHE_TTL: "60" is not a duration`},
+		{"number in the environment", valid + "}", map[string]string{"WUFOO_CONCURRENCY": "four"}, `WUFOO_CONCURRENCY: "four" is not a number`},
+		{"counters in the environment", valid + "}", map[string]string{"WUFOO_COUNTERS": "applicants"}, "WUFOO_COUNTERS: counter"},
+		{"ambiguous account", `{"accounts": [
+			{"account": "railsgirlssb", "api_key": "ABCD-EFGH-IJKL-MNOP", "form_ids": ["applicants"]},
+			{"account": "railsgirlsberlin", "api_key": "QRST-UVWX-YZ12-3456", "form_ids": ["berlin"]}
+		]}`, map[string]string{"WUFOO_API_KEY": "ZZZZ-EFGH-IJKL-MNOP"}, "account #3: name is empty"},
+		{"invalid", valid + `, "concurrency": -1}`, nil, "concurrency must be at least 1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{}
+			for name, value := range test.env {
+				env[name] = value
+			}
+			if len(test.file) > 0 {
+				env["WUFOO_CONFIG"] = writeConfig(t, test.file)
+			}
+			setupEnv(t, env)
+
+			if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected %q, got %v", test.err, err)
+			}
+		})
+	}
+}
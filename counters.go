@@ -0,0 +1,188 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CounterConfig is a named total over a group of forms. A form is written as
+// its ID, or as account/ID when several accounts are configured. With
+// Filters only the matching entries are counted, see wufoo.Filter.
+type CounterConfig struct {
+	Name     string   `json:"name"`
+	Forms    []string `json:"forms"`
+	Capacity int      `json:"capacity"`
+	Filters  []string `json:"filters"`
+	Match    string   `json:"match"`
+}
+
+type formKey struct {
+	Account string
+	FormId  string
+}
+
+var (
+	counterNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	nowPattern         = regexp.MustCompile(`\{now(-[^}]*)?\}`)
+)
+
+// filter resolves the filter of counter at now. Values may contain {now} or
+// {now-168h}, which are replaced by that time in Wufoo's date format.
+func (counter CounterConfig) filter(now time.Time) (wufoo.Filter, error) {
+	filter := wufoo.Filter{Match: counter.Match}
+	for _, rule := range counter.Filters {
+		var err error
+		rule = nowPattern.ReplaceAllStringFunc(rule, func(placeholder string) string {
+			t := now
+			if match := nowPattern.FindStringSubmatch(placeholder); len(match[1]) > 0 {
+				d, e := time.ParseDuration(match[1][1:])
+				if e != nil {
+					err = fmt.Errorf("filter %q: %s is not a duration like 168h", rule, match[1][1:])
+				}
+				t = t.Add(-d)
+			}
+			return t.Format("2006-01-02 15:04:05")
+		})
+		if err != nil {
+			return filter, err
+		}
+		filter.Rules = append(filter.Rules, rule)
+	}
+	return filter, filter.Validate()
+}
+
+// parseCounters reads counters written like "applicants=f1,f2;coaches=f3".
+// A capacity may follow the name: "applicants:40=f1,f2".
+func parseCounters(value string) ([]CounterConfig, error) {
+	var counters []CounterConfig
+	for _, definition := range strings.Split(value, ";") {
+		if len(strings.TrimSpace(definition)) < 1 {
+			continue
+		}
+		parts := strings.SplitN(definition, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("counter %q must be written as name=form1,form2", definition)
+		}
+		counter := CounterConfig{Name: strings.TrimSpace(parts[0])}
+		if i := strings.Index(counter.Name, ":"); i >= 0 {
+			capacity, err := strconv.Atoi(strings.TrimSpace(counter.Name[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("counter %q: capacity must be a number", definition)
+			}
+			counter.Name, counter.Capacity = strings.TrimSpace(counter.Name[:i]), capacity
+		}
+		for _, form := range strings.Split(parts[1], ",") {
+			counter.Forms = append(counter.Forms, strings.TrimSpace(form))
+		}
+		counters = append(counters, counter)
+	}
+	return counters, nil
+}
+
+// Counter looks up a counter by name.
+func (c WufooConfig) Counter(name string) (CounterConfig, bool) {
+	for _, counter := range c.Counters {
+		if counter.Name == name {
+			return counter, true
+		}
+	}
+	return CounterConfig{}, false
+}
+
+// counterForms resolves the forms of counter against the configured accounts.
+func (c WufooConfig) counterForms(counter CounterConfig) (map[formKey]bool, error) {
+	forms := map[formKey]bool{}
+	for _, form := range counter.Forms {
+		key, err := c.resolveForm(form)
+		if err != nil {
+			return nil, err
+		}
+		forms[key] = true
+	}
+	return forms, nil
+}
+
+// resolveForm looks up a form written as its ID or as account/ID.
+func (c WufooConfig) resolveForm(form string) (formKey, error) {
+	var matches []formKey
+	for _, ref := range c.Forms() {
+		if form == ref.FormId || form == ref.Account.Account+"/"+ref.FormId {
+			matches = append(matches, formKey{ref.Account.Account, ref.FormId})
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return formKey{}, fmt.Errorf("form %q is not configured for any account", form)
+	case 1:
+		return matches[0], nil
+	default:
+		return formKey{}, fmt.Errorf("form %q belongs to several accounts, write it as account/%s", form, form)
+	}
+}
+
+// fetchedForms lists all forms, followed by every form of every counter
+// with filters once more, for its filtered count.
+func (c WufooConfig) fetchedForms() []formRef {
+	refs := c.Forms()
+	for i, counter := range c.Counters {
+		if len(counter.Filters) < 1 {
+			continue
+		}
+		forms, _ := c.counterForms(counter)
+		for _, ref := range c.Forms() {
+			if forms[formKey{ref.Account.Account, ref.FormId}] {
+				ref.Counter = &c.Counters[i]
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+// counterSelector picks the forms of counter. The configuration has been
+// validated at startup, so unresolvable forms can't occur here.
+func (c WufooConfig) counterSelector(counter CounterConfig) formSelector {
+	if len(counter.Filters) > 0 {
+		return func(form FormCount) bool {
+			return form.Counter == counter.Name
+		}
+	}
+	forms, _ := c.counterForms(counter)
+	return func(form FormCount) bool {
+		return len(form.Counter) < 1 && forms[formKey{form.Account, form.FormId}]
+	}
+}
+
+func (c WufooConfig) validateCounters() []string {
+	var problems []string
+	seen := map[string]bool{}
+	for i, counter := range c.Counters {
+		name := fmt.Sprintf("counter %q", counter.Name)
+		if !counterNamePattern.MatchString(counter.Name) {
+			name = fmt.Sprintf("counter #%d", i+1)
+			problems = append(problems, name+": name may only contain letters, digits, dashes and underscores")
+		} else if seen[counter.Name] {
+			problems = append(problems, name+" is listed more than once")
+		}
+		seen[counter.Name] = true
+
+		if len(counter.Forms) < 1 {
+			problems = append(problems, name+": no forms configured")
+		}
+		if counter.Capacity < 0 {
+			problems = append(problems, name+": capacity must not be negative")
+		}
+		if _, err := c.counterForms(counter); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+		if _, err := counter.filter(time.Now()); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/martini-contrib/render"
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"net/http"
+)
+
+// ErrCircuitOpen is reported for forms of an account whose circuit breaker
+// is open, in addition to the error codes of the wufoo package.
+const ErrCircuitOpen = "circuit_open"
+
+// errorStatus is the HTTP status we answer with when an error with code
+// reaches a handler.
+func errorStatus(code string) int {
+	switch code {
+	case wufoo.ErrAuthFailed:
+		return http.StatusUnauthorized
+	case wufoo.ErrFormNotFound:
+		return http.StatusNotFound
+	case wufoo.ErrRateLimited:
+		return http.StatusTooManyRequests
+	case wufoo.ErrUpstreamTimeout:
+		return http.StatusGatewayTimeout
+	case ErrCircuitOpen:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func renderError(r render.Render, err error) {
+	we, ok := err.(*wufoo.Error)
+	if !ok {
+		we = &wufoo.Error{Code: wufoo.ErrUpstream, Err: err}
+	}
+	r.JSON(errorStatus(we.Code), map[string]interface{}{
+		"error":   "can't fetch information",
+		"code":    we.Code,
+		"account": we.Account,
+		"form_id": we.FormId,
+		"message": we.Err.Error(),
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowClient holds every successful count request of a fake back for
+// delay and records how many were in flight at most. Errors are returned
+// right away.
+type slowClient struct {
+	*wufoo.Fake
+	delay time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *slowClient) CountEntries(ctx context.Context, formId string, filter wufoo.Filter) (int, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+
+	count, err := c.Fake.CountEntries(ctx, formId, filter)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case <-time.After(c.delay):
+		return count, nil
+	case <-ctx.Done():
+		return 0, &wufoo.Error{Code: wufoo.ErrUpstream, Account: c.Account, FormId: formId, Err: ctx.Err(), Retryable: true}
+	}
+}
+
+// setupSlow points the app at a slowClient serving formIds, form i
+// holding i entries.
+func setupSlow(t *testing.T, formIds ...string) *slowClient {
+	fake, _ := setupFake(t, WufooConfig{Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: formIds}}})
+	for i, formId := range formIds {
+		fake.SetCount(formId, i)
+	}
+	client := &slowClient{Fake: fake, delay: 10 * time.Millisecond}
+	clients["railsgirlssb"] = client
+	return client
+}
+
+func TestFetchCountsBoundsWorkers(t *testing.T) {
+	var formIds []string
+	for i := 0; i < 10; i++ {
+		formIds = append(formIds, fmt.Sprintf("form%d", i))
+	}
+	client := setupSlow(t, formIds...)
+
+	counts, err := fetchCounts(context.Background(), wufooConfig.fetchedForms(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, count := range counts {
+		if count != i {
+			t.Errorf("expected count %d at %d, got %d", i, i, count)
+		}
+	}
+	if client.maxInFlight != 3 {
+		t.Errorf("expected 3 concurrent requests, got %d", client.maxInFlight)
+	}
+}
+
+func TestFetchCountsStopsAfterFirstError(t *testing.T) {
+	client := setupSlow(t, "broken", "form1", "form2", "form3", "form4", "form5")
+	client.SetError("broken", &wufoo.Error{Code: wufoo.ErrAuthFailed, Account: "railsgirlssb", FormId: "broken", Err: errors.New("unexpected status 401")})
+	client.delay = time.Second
+
+	start := time.Now()
+	_, err := fetchCounts(context.Background(), wufooConfig.fetchedForms(), 2)
+	if we, ok := err.(*wufoo.Error); !ok || we.FormId != "broken" {
+		t.Errorf("expected the error of the broken form, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the running request to be cancelled, took %s", elapsed)
+	}
+	for _, formId := range []string{"form2", "form3", "form4", "form5"} {
+		if n := calls(client.Fake, formId); n != 0 {
+			t.Errorf("expected %s not to be requested after the error, got %d calls", formId, n)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"fmt"
+	"time"
+)
+
+// check is one line of the /readyz report.
+type check struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// readiness tells whether this instance can answer with counts that are at
+// most threshold old, and why not.
+func readiness(cache *countCache, threshold time.Duration) (bool, map[string]interface{}) {
+	lastSuccess, lastErr := cache.LastSuccess()
+	forms := cache.Snapshot()
+
+	fresh := check{OK: true}
+	switch {
+	case lastSuccess.IsZero():
+		fresh = check{false, "no successful fetch yet"}
+	case time.Since(lastSuccess) > threshold:
+		fresh = check{false, fmt.Sprintf("last successful fetch is older than %s", threshold)}
+	}
+	if lastErr != nil && !fresh.OK {
+		fresh.Message += ": " + lastErr.Error()
+	}
+
+	credentials, resolvable := check{OK: true}, check{OK: true}
+	for _, form := range forms {
+		switch form.Error {
+		case wufoo.ErrAuthFailed:
+			credentials = check{false, fmt.Sprintf("Wufoo rejected the API key of account %s", form.Account)}
+		case wufoo.ErrFormNotFound:
+			resolvable = check{false, fmt.Sprintf("form %s of account %s does not exist", form.FormId, form.Account)}
+		}
+	}
+
+	// Open circuits are only reported: this instance still serves the last
+	// counts, and taking it out of rotation wouldn't reach Wufoo any sooner.
+	// If the circuit stays open the fresh check fails after threshold.
+	ready := fresh.OK && credentials.OK && resolvable.OK
+	body := map[string]interface{}{
+		"ready":    ready,
+		"circuits": circuitStates(),
+		"checks": map[string]check{
+			"fresh":       fresh,
+			"credentials": credentials,
+			"forms":       resolvable,
+		},
+	}
+	if !lastSuccess.IsZero() {
+		body["last_success"] = lastSuccess
+	}
+	return ready, body
+}
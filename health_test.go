@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	_, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+	})
+	if status, body := get(t, cache, "/healthz"); status != 200 || body["alive"] != true {
+		t.Errorf("expected the app to be alive before any fetch, got %d %v", status, body)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name string
+		// err is returned by Wufoo for the applicants form; without it the
+		// form has 30 entries.
+		err   error
+		fetch bool
+		wait  time.Duration
+		check string
+	}{
+		{name: "ready", fetch: true},
+		{name: "no fetch yet", check: "fresh"},
+		{name: "too old", fetch: true, wait: 30 * time.Millisecond, check: "fresh"},
+		{name: "credentials", err: &wufoo.Error{Code: wufoo.ErrAuthFailed, Account: "railsgirlssb", FormId: "applicants", Err: errors.New("unexpected status 401")}, fetch: true, check: "credentials"},
+		{name: "missing form", err: &wufoo.Error{Code: wufoo.ErrFormNotFound, Account: "railsgirlssb", FormId: "applicants", Err: errors.New("unexpected status 404")}, fetch: true, check: "forms"},
+	}
+	for _, test := range tests {
+		fake, cache := setupFake(t, WufooConfig{
+			Accounts:       []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+			Retry:          RetryPolicy{MaxAttempts: 1},
+			ReadyThreshold: 20 * time.Millisecond,
+		})
+		fake.SetCount("applicants", 30)
+		fake.SetError("applicants", test.err)
+		if test.fetch {
+			get(t, cache, "/")
+		}
+		time.Sleep(test.wait)
+
+		status, body := get(t, cache, "/readyz")
+		checks, _ := body["checks"].(map[string]interface{})
+		if len(test.check) < 1 {
+			if status != 200 || body["ready"] != true {
+				t.Errorf("%s: expected 200, got %d %v", test.name, status, body)
+			}
+			continue
+		}
+		if status != 503 || body["ready"] != false {
+			t.Errorf("%s: expected 503, got %d %v", test.name, status, body)
+		}
+		if check, _ := checks[test.check].(map[string]interface{}); check["ok"] != false || check["message"] == nil {
+			t.Errorf("%s: expected the %s check to fail with a message, got %v", test.name, test.check, checks)
+		}
+	}
+}
+
+func TestReadinessWithOpenCircuit(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts:         []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		Retry:            RetryPolicy{MaxAttempts: 1},
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Minute,
+		ReadyThreshold:   time.Minute,
+	})
+	setupBreakers(wufooConfig)
+	fake.SetCount("applicants", 30)
+	get(t, cache, "/")
+
+	breakers["railsgirlssb"].Allow(context.Background())
+	breakers["railsgirlssb"].Record(errUnavailable)
+	status, body := get(t, cache, "/readyz")
+	circuits, _ := body["circuits"].(map[string]interface{})
+	if status != 200 || body["ready"] != true || circuits["railsgirlssb"] != CircuitOpen {
+		t.Errorf("expected the open circuit to be reported without failing readiness, got %d %v", status, body)
+	}
+}
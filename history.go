@@ -0,0 +1,199 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// historyPoint is one bucket of a time series. Count is the last count seen
+// within the bucket and New how much it grew during the bucket.
+type historyPoint struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+	New   int       `json:"new"`
+}
+
+// historyIntervals are the bucket sizes /history offers.
+var historyIntervals = map[string]time.Duration{"hour": time.Hour, "day": 24 * time.Hour}
+
+// compactInterval is how often the store is rid of expired hours.
+const compactInterval = 24 * time.Hour
+
+// history keeps the first and last count of every form per hour for
+// retention, and the latest snapshot to restore the cache from. With a
+// store both survive restarts.
+type history struct {
+	sync.Mutex
+
+	retention   time.Duration
+	store       historyStore
+	hours       []historyHour
+	latest      *snapshot
+	compactedAt time.Time
+}
+
+// historyHour holds the counts of all forms seen within one hour.
+type historyHour struct {
+	Time  time.Time   `json:"time"`
+	Forms []hourCount `json:"forms"`
+}
+
+// hourCount is the first and last count of a form within an hour.
+type hourCount struct {
+	Account string `json:"account"`
+	FormId  string `json:"form_id"`
+	First   int    `json:"first"`
+	Last    int    `json:"last"`
+}
+
+var appHistory = newHistory(30*24*time.Hour, nil)
+
+func newHistory(retention time.Duration, store historyStore) *history {
+	return &history{retention: retention, store: store}
+}
+
+func setupHistory(config WufooConfig) error {
+	var store historyStore
+	if config.Storage == "bolt" {
+		s, err := newBoltStore(config.StoragePath)
+		if err != nil {
+			return err
+		}
+		store = s
+	}
+	appHistory = newHistory(config.HistoryRetention, store)
+	return appHistory.load(time.Now())
+}
+
+// load reads the hours that haven't expired at now and the latest snapshot
+// from the store.
+func (h *history) load(now time.Time) error {
+	if h.store == nil {
+		return nil
+	}
+	if err := h.store.Compact(now.Add(-h.retention)); err != nil {
+		return err
+	}
+	hours, latest, err := h.store.Load(now.Add(-h.retention))
+	if err != nil {
+		return err
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.hours, h.latest, h.compactedAt = hours, latest, now
+	return nil
+}
+
+// last returns the latest snapshot, if there is one.
+func (h *history) last() (snapshot, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if h.latest == nil {
+		return snapshot{}, false
+	}
+	return *h.latest, true
+}
+
+// record adds the counts of forms fetched at the given time. The store is
+// written after the in-memory history has been updated and unlocked; a
+// failing store is logged but doesn't stop the in-memory history.
+func (h *history) record(at time.Time, forms []FormCount) {
+	snap := snapshot{Time: at, Forms: make([]FormCount, len(forms))}
+	copy(snap.Forms, forms)
+
+	h.Lock()
+	ended := h.add(snap)
+	hour := h.hours[len(h.hours)-1]
+	hour.Forms = append([]hourCount(nil), hour.Forms...)
+	compact := h.store != nil && at.Sub(h.compactedAt) > compactInterval
+	if compact {
+		h.compactedAt = at
+	}
+	h.Unlock()
+
+	if h.store == nil {
+		return
+	}
+	if ended != nil {
+		if err := h.store.AppendHour(*ended); err != nil {
+			log.Printf("saving counts failed: %s", err)
+		}
+	}
+	if err := h.store.SaveCurrent(hour, snap); err != nil {
+		log.Printf("saving counts failed: %s", err)
+	}
+	if compact {
+		if err := h.store.Compact(at.Add(-h.retention)); err != nil {
+			log.Printf("compacting saved counts failed: %s", err)
+		}
+	}
+}
+
+// add merges snap into the hour it was taken in and drops the hours before
+// it that have expired since. Filtered counts are left out. If snap starts
+// a new hour, the one before has ended and is returned.
+func (h *history) add(snap snapshot) (ended *historyHour) {
+	h.latest = &snap
+
+	start := snap.Time.UTC().Truncate(time.Hour)
+	if n := len(h.hours); n < 1 || !h.hours[n-1].Time.Equal(start) {
+		if n > 0 {
+			ended = &historyHour{Time: h.hours[n-1].Time, Forms: h.hours[n-1].Forms}
+		}
+		h.hours = append(h.hours, historyHour{Time: start})
+	}
+	hour := &h.hours[len(h.hours)-1]
+	for _, form := range snap.Forms {
+		if len(form.Counter) > 0 {
+			continue
+		}
+		found := false
+		for i := range hour.Forms {
+			if hour.Forms[i].Account == form.Account && hour.Forms[i].FormId == form.FormId {
+				hour.Forms[i].Last, found = form.EntryCount, true
+			}
+		}
+		if !found {
+			hour.Forms = append(hour.Forms, hourCount{form.Account, form.FormId, form.EntryCount, form.EntryCount})
+		}
+	}
+
+	expired := 0
+	for expired < len(h.hours)-1 && snap.Time.Sub(h.hours[expired].Time) > h.retention {
+		expired++
+	}
+	h.hours = h.hours[expired:]
+	return ended
+}
+
+// series sums the forms picked by selector and buckets them by interval,
+// which must be a multiple of an hour. Buckets without counts are left out.
+func (h *history) series(selector func(formKey) bool, interval time.Duration) []historyPoint {
+	h.Lock()
+	defer h.Unlock()
+
+	points := []historyPoint{}
+	for _, hour := range h.hours {
+		first, last := 0, 0
+		for _, form := range hour.Forms {
+			if selector == nil || selector(formKey{form.Account, form.FormId}) {
+				first += form.First
+				last += form.Last
+			}
+		}
+
+		bucket := hour.Time.Truncate(interval)
+		if n := len(points); n > 0 && points[n-1].Time.Equal(bucket) {
+			points[n-1].New += last - points[n-1].Count
+			points[n-1].Count = last
+			continue
+		}
+		if n := len(points); n > 0 {
+			first = points[n-1].Count
+		}
+		points = append(points, historyPoint{Time: bucket, Count: last, New: last - first})
+	}
+	return points
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistorySeries(t *testing.T) {
+	h := newHistory(24*time.Hour, nil)
+	start := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i, counts := range [][2]int{{1, 0}, {3, 1}, {4, 1}, {6, 2}, {9, 2}} {
+		h.record(start.Add(time.Duration(i)*30*time.Minute), []FormCount{
+			{Account: "railsgirlssb", FormId: "applicants", EntryCount: counts[0]},
+			{Account: "railsgirlssb", FormId: "coaches", EntryCount: counts[1]},
+			{Account: "railsgirlssb", FormId: "applicants", Counter: "berlin", EntryCount: 100},
+		})
+	}
+
+	points := h.series(nil, time.Hour)
+	expected := []historyPoint{
+		{start, 4, 3},
+		{start.Add(time.Hour), 8, 4},
+		{start.Add(2 * time.Hour), 11, 3},
+	}
+	if len(points) != len(expected) {
+		t.Fatalf("expected %d points, got %v", len(expected), points)
+	}
+	for i := range expected {
+		if points[i] != expected[i] {
+			t.Errorf("point %d: expected %v, got %v", i, expected[i], points[i])
+		}
+	}
+
+	applicants := h.series(func(form formKey) bool { return form.FormId == "applicants" }, 24*time.Hour)
+	if len(applicants) != 1 || applicants[0].Count != 9 || applicants[0].New != 8 {
+		t.Errorf("unexpected daily series %v", applicants)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	h := newHistory(time.Hour, nil)
+	start := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		h.record(start.Add(time.Duration(i)*30*time.Minute), []FormCount{{FormId: "applicants", EntryCount: i}})
+	}
+	if points := h.series(nil, time.Hour); len(points) != 2 || points[0].Time != start.Add(time.Hour) {
+		t.Errorf("expected samples older than an hour to be dropped, got %v", points)
+	}
+}
+
+func TestHistoryKeepsOnePointPerHour(t *testing.T) {
+	h := newHistory(24*time.Hour, nil)
+	start := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 180; i++ {
+		h.record(start.Add(time.Duration(i)*time.Minute), []FormCount{
+			{Account: "railsgirlssb", FormId: "applicants", EntryCount: i},
+			{Account: "railsgirlssb", FormId: "coaches", EntryCount: 1},
+		})
+	}
+	if len(h.hours) != 3 {
+		t.Fatalf("expected 3 hours, got %d", len(h.hours))
+	}
+	for _, hour := range h.hours {
+		if len(hour.Forms) != 2 {
+			t.Errorf("%s: expected one point per form, got %v", hour.Time, hour.Forms)
+		}
+	}
+	if last, _ := h.last(); !last.Time.Equal(start.Add(179*time.Minute)) || last.Forms[0].EntryCount != 179 {
+		t.Errorf("expected the latest snapshot to be kept, got %v", last)
+	}
+}
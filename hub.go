@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+)
+
+// changeEvent reports forms whose count changed, together with the new total.
+type changeEvent struct {
+	Count int         `json:"count"`
+	Forms []FormCount `json:"forms"`
+}
+
+// changeHub fans change events out to every subscriber. Subscribers that
+// can't keep up are dropped by closing their channel.
+type changeHub struct {
+	sync.Mutex
+	subscribers map[chan changeEvent]bool
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind.
+const subscriberBuffer = 16
+
+var countChanges = newChangeHub()
+
+func newChangeHub() *changeHub {
+	return &changeHub{subscribers: map[chan changeEvent]bool{}}
+}
+
+func (h *changeHub) subscribe() chan changeEvent {
+	h.Lock()
+	defer h.Unlock()
+	ch := make(chan changeEvent, subscriberBuffer)
+	h.subscribers[ch] = true
+	return ch
+}
+
+func (h *changeHub) unsubscribe(ch chan changeEvent) {
+	h.Lock()
+	defer h.Unlock()
+	if h.subscribers[ch] {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (h *changeHub) publish(event changeEvent) {
+	h.Lock()
+	defer h.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// changes compares two states of the same forms and returns the event to
+// publish, if any count changed.
+func changes(before, after []FormCount) (changeEvent, bool) {
+	event := changeEvent{Forms: []FormCount{}}
+	for i, form := range after {
+		if len(form.Counter) < 1 {
+			event.Count += form.EntryCount
+		}
+		if i >= len(before) || before[i].EntryCount != form.EntryCount || before[i].FetchedAt.IsZero() {
+			event.Forms = append(event.Forms, form)
+		}
+	}
+	return event, len(event.Forms) > 0
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/gorilla/websocket"
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/martini-contrib/render"
+
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pingInterval is how often live connections are pinged. A connection that
+// stays silent for two intervals is closed.
+const pingInterval = 30 * time.Second
+
+// writeTimeout limits how long a message to a live connection may take.
+const writeTimeout = 10 * time.Second
+
+// maxLiveRequestSize is the largest message a client may send.
+const maxLiveRequestSize = 64 * 1024
+
+// liveConnections counts the open WebSocket connections.
+var liveConnections int64
+
+// upgrader accepts connections from any origin, like the CORS headers of
+// the other endpoints, as the widgets are embedded on other sites.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// liveConn is a WebSocket connection that can be written to from several
+// goroutines.
+type liveConn struct {
+	*websocket.Conn
+	writeLock sync.Mutex
+}
+
+func (c *liveConn) send(body map[string]interface{}) {
+	data, _ := json.Marshal(body)
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := c.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Printf("live connection: %s", err)
+	}
+}
+
+// closeWithStatus tells the client why the connection ends.
+func (c *liveConn) closeWithStatus(code int, reason string) {
+	c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeTimeout))
+}
+
+// liveRequest is what clients send to change their subscriptions.
+type liveRequest struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+}
+
+// liveHandler upgrades to a WebSocket connection that pushes the named
+// counters given in ?counters=a,b or subscribed to later with
+// {"subscribe": ["a"]} whenever they change.
+func liveHandler(cache *countCache) func(render.Render, http.ResponseWriter, *http.Request) {
+	return func(r render.Render, w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt64(&liveConnections, 1) > int64(wufooConfig.WebSocketLimit) {
+			atomic.AddInt64(&liveConnections, -1)
+			r.JSON(503, map[string]interface{}{"error": "too many live connections"})
+			return
+		}
+		defer atomic.AddInt64(&liveConnections, -1)
+
+		ws, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		conn := &liveConn{Conn: ws}
+		defer conn.Close()
+		// Reading fails when nothing, not even a pong, arrived for two pings.
+		conn.SetReadLimit(maxLiveRequestSize)
+		conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		})
+
+		// Hijacked requests aren't cancelled when the client goes away.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		events := countChanges.subscribe()
+		defer countChanges.unsubscribe(events)
+
+		requests := make(chan liveRequest)
+		go func() {
+			defer cancel()
+			for {
+				_, message, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+				var request liveRequest
+				if err := json.Unmarshal(message, &request); err != nil {
+					conn.send(map[string]interface{}{"error": "invalid request"})
+					continue
+				}
+				select {
+				case requests <- request:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		subscriptions := map[string]int{}
+		subscribe := func(names []string) {
+			for _, name := range names {
+				if _, ok := wufooConfig.Counter(name); !ok {
+					conn.send(map[string]interface{}{"error": "unknown counter", "counter": name})
+				} else if _, ok := subscriptions[name]; !ok {
+					subscriptions[name] = -1
+				}
+			}
+		}
+		update := func() {
+			for name, last := range subscriptions {
+				counter, _ := wufooConfig.Counter(name)
+				count, err := cache.Get(ctx, wufooConfig.counterSelector(counter))
+				if err == nil && count != last {
+					subscriptions[name] = count
+					conn.send(counterBody(counter, count))
+				}
+			}
+		}
+
+		if names := req.URL.Query().Get("counters"); len(names) > 0 {
+			subscribe(strings.Split(names, ","))
+			update()
+		}
+		ping := time.NewTicker(pingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case request := <-requests:
+				subscribe(request.Subscribe)
+				for _, name := range request.Unsubscribe {
+					delete(subscriptions, name)
+				}
+				update()
+			case _, ok := <-events:
+				if !ok {
+					conn.closeWithStatus(websocket.CloseGoingAway, "too slow")
+					return
+				}
+				update()
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
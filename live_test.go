@@ -0,0 +1,104 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/gorilla/websocket"
+
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dialLive opens a WebSocket connection to path and returns the status of
+// the handshake. Once the test has closed the connection, it waits for the
+// handler to return, which would otherwise see the next test's config.
+func dialLive(t *testing.T, server *httptest.Server, path string) (*websocket.Conn, int) {
+	conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, nil)
+	if res == nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt64(&liveConnections) > 0; {
+			if time.Now().After(deadline) {
+				t.Fatal("expected the live handler to return")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+	return conn, res.StatusCode
+}
+
+// readLive decodes the next message.
+func readLive(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var body map[string]interface{}
+	if err := conn.ReadJSON(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestLive(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts:       []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants", "coaches"}}},
+		Counters:       []CounterConfig{{Name: "applicants", Forms: []string{"applicants"}, Capacity: 40}},
+		Retry:          RetryPolicy{MaxAttempts: 1},
+		WebSocketLimit: 1,
+	})
+	fake.SetCount("applicants", 30)
+	fake.SetCount("coaches", 5)
+
+	server := httptest.NewServer(newServer(cache))
+	defer server.Close()
+	conn, status := dialLive(t, server, "/live?counters=applicants,nope")
+	if status != 101 {
+		t.Fatalf("expected 101, got %d", status)
+	}
+	defer conn.Close()
+
+	if body := readLive(t, conn); body["error"] != "unknown counter" || body["counter"] != "nope" {
+		t.Errorf("expected an error for the unknown counter, got %v", body)
+	}
+	if body := readLive(t, conn); body["counter"] != "applicants" || body["count"] != 30.0 {
+		t.Errorf("expected the current count, got %v", body)
+	}
+
+	fake.SetCount("coaches", 6)
+	fake.SetCount("applicants", 31)
+	<-cache.refresh(true).done
+	if body := readLive(t, conn); body["count"] != 31.0 || body["remaining"] != 9.0 {
+		t.Errorf("expected the changed count, got %v", body)
+	}
+
+	if _, status := dialLive(t, server, "/live"); status != 503 {
+		t.Errorf("expected 503 above the connection limit, got %d", status)
+	}
+}
+
+func TestLiveRequests(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts:       []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		Counters:       []CounterConfig{{Name: "applicants", Forms: []string{"applicants"}}},
+		Retry:          RetryPolicy{MaxAttempts: 1},
+		WebSocketLimit: 1,
+	})
+	fake.SetCount("applicants", 30)
+
+	server := httptest.NewServer(newServer(cache))
+	defer server.Close()
+	conn, status := dialLive(t, server, "/live")
+	if status != 101 {
+		t.Fatalf("expected 101, got %d", status)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte("applicants"))
+	if body := readLive(t, conn); body["error"] != "invalid request" {
+		t.Errorf("expected an error for a request that isn't JSON, got %v", body)
+	}
+	conn.WriteJSON(liveRequest{Subscribe: []string{"applicants"}})
+	if body := readLive(t, conn); body["counter"] != "applicants" || body["count"] != 30.0 {
+		t.Errorf("expected the current count after subscribing, got %v", body)
+	}
+}
@@ -4,51 +4,142 @@ import (
 	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/go-martini/martini"
 	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/martini-contrib/cors"
 	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/martini-contrib/render"
-	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/gopkg.in/resty.v0"
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
 
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
+	"log"
+	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
 )
 
-type WufooConfig struct {
-	Account  string
-	ApiKey   string
-	Password string
-	FormIds  []string
-}
-
 var wufooConfig WufooConfig
 
-func count() (int, error) {
-	count := 0
+// clients holds one Wufoo client per account.
+var clients = map[string]wufoo.Client{}
 
-	type Result struct {
-		EntryCount string `json:"EntryCount"`
+func setupClients(config WufooConfig) error {
+	options := wufoo.Options{
+		Timeout:            config.HTTP.Timeout,
+		UserAgent:          config.HTTP.UserAgent,
+		Proxy:              config.HTTP.Proxy,
+		CAFile:             config.HTTP.CAFile,
+		InsecureSkipVerify: config.HTTP.InsecureSkipVerify,
+		Observe:            appMetrics.observeFetch,
 	}
-
-	for _, formId := range wufooConfig.FormIds {
-		resp, err := resty.R().
-			SetHeader("Accept", "application/json").
-			SetBasicAuth(wufooConfig.ApiKey, wufooConfig.Password).
-			Get(fmt.Sprintf("https://%s.wufoo.com/api/v3/forms/%s/entries/count.json", wufooConfig.Account, formId))
+	for _, account := range config.Accounts {
+		options.BaseURL = config.HTTP.BaseURL(account.Account)
+		client, err := wufoo.New(account.Account, account.ApiKey, account.Password, options)
 		if err != nil {
-			return count, err
+			return err
 		}
+		clients[account.Account] = client
+	}
+	return nil
+}
 
-		var result Result
-		err = json.Unmarshal(resp.Body, &result)
-		if err != nil {
-			return count, err
+func count(ctx context.Context) ([]int, error) {
+	return fetchCounts(ctx, wufooConfig.fetchedForms(), wufooConfig.Concurrency)
+}
+
+// fetchForm fetches one form through the circuit breaker of its account and
+// the retry policy.
+func fetchForm(ctx context.Context, form formRef) (int, error) {
+	breaker := breakers[form.Account.Account]
+	if breaker != nil && !breaker.Allow(ctx) {
+		return 0, &wufoo.Error{Code: ErrCircuitOpen, Account: form.Account.Account, FormId: form.FormId, Err: errors.New("circuit breaker is open")}
+	}
+
+	var filter wufoo.Filter
+	if form.Counter != nil {
+		// Validated at startup, only {now} changes from fetch to fetch.
+		filter, _ = form.Counter.filter(time.Now())
+	}
+	entryCount, err := wufooConfig.Retry.Do(ctx, form, func() (int, error) {
+		return clients[form.Account.Account].CountEntries(ctx, form.FormId, filter)
+	})
+	if breaker != nil {
+		if ctx.Err() != nil {
+			breaker.Record(ctx.Err())
+		} else {
+			breaker.Record(err)
+		}
+	}
+	return entryCount, err
+}
+
+// fetchCounts fetches the entry count of every form using at most workers
+// concurrent requests. counts[i] belongs to forms[i]. The first error stops
+// all other forms and is returned right away.
+func fetchCounts(ctx context.Context, forms []formRef, workers int) ([]int, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(forms) {
+		workers = len(forms)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	counts := make([]int, len(forms))
+	jobs := make(chan int)
+	errs := make(chan error, workers)
+
+	go func() {
+		defer close(jobs)
+		for i := range forms {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
 		}
-		entryCount, _ := strconv.Atoi(result.EntryCount)
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				entryCount, err := fetchForm(ctx, forms[i])
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				counts[i] = entryCount
+			}
+		}()
+	}
 
-		count += entryCount
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case err := <-errs:
+		return nil, err
+	case <-done:
+	}
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+		return counts, nil
 	}
+}
 
-	return count, nil
+func counterBody(counter CounterConfig, count int) map[string]interface{} {
+	return newCapacity(count, counter.Capacity).addTo(map[string]interface{}{"counter": counter.Name, "count": count})
 }
 
 func main() {
@@ -57,24 +148,151 @@ func main() {
 		port = "8080"
 	}
 
-	wufooConfig.Account = os.Getenv("WUFOO_ACCOUNT")
-	wufooConfig.ApiKey = os.Getenv("WUFOO_API_KEY")
-	wufooConfig.Password = "any"
-	wufooConfig.FormIds = strings.Split(os.Getenv("WUFOO_FORM_IDS"), ",")
+	var err error
+	wufooConfig, err = loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := setupClients(wufooConfig); err != nil {
+		log.Fatal(err)
+	}
+	setupBreakers(wufooConfig)
+	if err := setupHistory(wufooConfig); err != nil {
+		log.Fatalf("loading saved counts: %s", err)
+	}
+	cache := newCountCache(wufooConfig.CacheTTL, wufooConfig.RefreshInterval, wufooConfig.fetchedForms(), count)
+	if last, ok := appHistory.last(); ok && cache.restore(last) {
+		log.Printf("serving counts saved at %s until the first refresh", last.Time.Format(time.RFC3339))
+	}
+	go cache.Run(wufooConfig.RefreshInterval)
+
+	newServer(cache).RunOnAddr(":" + port)
+}
 
+// newServer sets up all routes on top of cache.
+func newServer(cache *countCache) *martini.ClassicMartini {
 	m := martini.Classic()
+	m.Use(appMetrics.Handler)
 	m.Use(render.Renderer())
 	m.Use(cors.Allow(&cors.Options{
 		AllowAllOrigins: true,
 	}))
 
-	m.Get("/", func(r render.Render) {
-		count, err := count()
+	m.Get("/", func(r render.Render, req *http.Request) {
+		count, err := cache.Get(req.Context(), nil)
+		if err != nil {
+			renderError(r, err)
+		} else {
+			body := map[string]interface{}{"count": count, "stale": cache.Stale(), "circuits": circuitStates()}
+			r.JSON(200, newCapacity(count, wufooConfig.Capacity).addTo(body))
+		}
+	})
+	m.Get("/forms", func(r render.Render, req *http.Request) {
+		var selector formSelector
+		if account := req.URL.Query().Get("account"); len(account) > 0 {
+			selector = accountSelector(account)
+		}
+		forms, _ := cache.Forms(req.Context(), selector)
+		for i, form := range forms {
+			forms[i].Capacity = newCapacity(form.EntryCount, wufooConfig.formCapacity(form.Account, form.FormId))
+		}
+		r.JSON(200, map[string]interface{}{"forms": forms})
+	})
+	m.Get("/accounts", func(r render.Render, req *http.Request) {
+		total, err := cache.Get(req.Context(), nil)
+		if err != nil {
+			renderError(r, err)
+			return
+		}
+		accounts := []map[string]interface{}{}
+		for _, account := range wufooConfig.Accounts {
+			count, _ := cache.Get(req.Context(), accountSelector(account.Account))
+			accounts = append(accounts, map[string]interface{}{"account": account.Account, "count": count})
+		}
+		r.JSON(200, map[string]interface{}{"count": total, "accounts": accounts})
+	})
+	m.Get("/accounts/:account", func(r render.Render, req *http.Request, params martini.Params) {
+		if _, ok := wufooConfig.Account(params["account"]); !ok {
+			r.JSON(404, map[string]interface{}{"error": "unknown account"})
+			return
+		}
+		count, err := cache.Get(req.Context(), accountSelector(params["account"]))
+		if err != nil {
+			renderError(r, err)
+		} else {
+			r.JSON(200, map[string]interface{}{"account": params["account"], "count": count})
+		}
+	})
+	m.Get("/counters", func(r render.Render, req *http.Request) {
+		if _, err := cache.Get(req.Context(), nil); err != nil {
+			renderError(r, err)
+			return
+		}
+		counters := []map[string]interface{}{}
+		for _, counter := range wufooConfig.Counters {
+			count, _ := cache.Get(req.Context(), wufooConfig.counterSelector(counter))
+			counters = append(counters, counterBody(counter, count))
+		}
+		r.JSON(200, map[string]interface{}{"counters": counters})
+	})
+	m.Get("/counters/:name", func(r render.Render, req *http.Request, params martini.Params) {
+		counter, ok := wufooConfig.Counter(params["name"])
+		if !ok {
+			r.JSON(404, map[string]interface{}{"error": "unknown counter"})
+			return
+		}
+		count, err := cache.Get(req.Context(), wufooConfig.counterSelector(counter))
 		if err != nil {
-			r.JSON(200, map[string]interface{}{"error": "can't fetch information"})
+			renderError(r, err)
+		} else {
+			r.JSON(200, counterBody(counter, count))
+		}
+	})
+	m.Get("/history", func(r render.Render, req *http.Request) {
+		name := req.URL.Query().Get("interval")
+		if len(name) < 1 {
+			name = "hour"
+		}
+		interval, ok := historyIntervals[name]
+		if !ok {
+			r.JSON(400, map[string]interface{}{"error": "interval must be hour or day"})
+			return
+		}
+
+		body := map[string]interface{}{"interval": name}
+		var selector func(formKey) bool
+		if form := req.URL.Query().Get("form"); len(form) > 0 {
+			key, err := wufooConfig.resolveForm(form)
+			if err != nil {
+				r.JSON(404, map[string]interface{}{"error": "unknown form", "message": err.Error()})
+				return
+			}
+			selector = func(k formKey) bool { return k == key }
+			body["account"], body["form_id"] = key.Account, key.FormId
+		}
+		body["points"] = appHistory.series(selector, interval)
+		r.JSON(200, body)
+	})
+	m.Get("/badge/:counter.svg", badgeHandler(cache))
+	m.Get("/embed", embedHandler(cache))
+	m.Get("/widget.js", widgetHandler())
+	m.Get("/stream", streamHandler(cache))
+	m.Get("/live", liveHandler(cache))
+	m.Post("/webhooks/wufoo", webhookHandler(cache))
+	m.Get("/healthz", func(r render.Render) {
+		r.JSON(200, map[string]interface{}{"alive": true})
+	})
+	m.Get("/readyz", func(r render.Render) {
+		ready, body := readiness(cache, wufooConfig.ReadyThreshold)
+		if ready {
+			r.JSON(200, body)
 		} else {
-			r.JSON(200, map[string]interface{}{"count": count})
+			r.JSON(503, body)
 		}
 	})
-	m.RunOnAddr(":" + port)
+	m.Get("/metrics", func(w http.ResponseWriter) {
+		appMetrics.Write(w, cache.Snapshot())
+	})
+	return m
 }
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+	"github.com/railsgirlssb/wufoo-count-app/wufoo/wufootest"
+
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testKey = "ABCD-EFGH-IJKL-MNOP"
+
+// setupTest points the app at server with a single account holding formIds
+// and returns a fresh cache.
+func setupTest(t *testing.T, server *wufootest.Server, formIds ...string) *countCache {
+	wufooConfig = WufooConfig{
+		Accounts:         []AccountConfig{{Account: "railsgirlssb", ApiKey: testKey, Password: "any", FormIds: formIds}},
+		CacheTTL:         time.Minute,
+		Concurrency:      4,
+		Retry:            RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		BreakerThreshold: 5,
+		BreakerCooldown:  time.Minute,
+	}
+	client, err := wufoo.New("railsgirlssb", testKey, "any", wufoo.Options{Timeout: 500 * time.Millisecond, BaseURL: server.BaseURL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients = map[string]wufoo.Client{"railsgirlssb": client}
+	breakers = map[string]*circuitBreaker{}
+	setupBreakers(wufooConfig)
+	return newCountCache(wufooConfig.CacheTTL, wufooConfig.RefreshInterval, wufooConfig.fetchedForms(), count)
+}
+
+// setupFake points the app at a fake client, without circuit breakers, and
+// returns it with a fresh cache. All accounts of config share the fake, so
+// their form IDs must differ. A zero cache TTL means a minute.
+func setupFake(t *testing.T, config WufooConfig) (*wufoo.Fake, *countCache) {
+	if len(config.Accounts) < 1 {
+		t.Fatal("expected at least one account")
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = time.Minute
+	}
+	wufooConfig = config
+	fake := wufoo.NewFake(config.Accounts[0].Account)
+	clients = map[string]wufoo.Client{}
+	for _, account := range config.Accounts {
+		clients[account.Account] = fake
+	}
+	breakers = map[string]*circuitBreaker{}
+	return fake, newCountCache(config.CacheTTL, config.RefreshInterval, config.fetchedForms(), count)
+}
+
+func get(t *testing.T, cache *countCache, path string) (int, map[string]interface{}) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	newServer(cache).ServeHTTP(res, req)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: invalid JSON %q", path, res.Body.String())
+	}
+	return res.Code, body
+}
+
+func TestIndexSumsAllForms(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetCount("applicants", 17)
+	server.SetCount("coaches", 5)
+
+	status, body := get(t, setupTest(t, server, "applicants", "coaches"), "/")
+	if status != 200 || body["count"] != 22.0 {
+		t.Errorf("expected 200 with count 22, got %d %v", status, body)
+	}
+}
+
+func TestIndexErrors(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetCount("applicants", 17)
+	server.SetResponse("limited", wufootest.Response{Status: 429})
+	server.SetResponse("broken", wufootest.Response{Status: 500})
+	server.SetResponse("garbage", wufootest.Response{Body: "{"})
+	server.SetResponse("slow", wufootest.Response{Body: `{"EntryCount":"1"}`, Delay: 2 * time.Second})
+
+	tests := []struct {
+		formId string
+		status int
+		code   string
+	}{
+		{"missing", 404, wufoo.ErrFormNotFound},
+		{"limited", 429, wufoo.ErrRateLimited},
+		{"broken", 502, wufoo.ErrUpstream},
+		{"garbage", 502, wufoo.ErrMalformedResponse},
+		{"slow", 504, wufoo.ErrUpstreamTimeout},
+	}
+	for _, test := range tests {
+		status, body := get(t, setupTest(t, server, "applicants", test.formId), "/")
+		if status != test.status || body["code"] != test.code || body["form_id"] != test.formId {
+			t.Errorf("%s: expected %d %s, got %d %v", test.formId, test.status, test.code, status, body)
+		}
+	}
+}
+
+func TestIndexAuthFailed(t *testing.T) {
+	server := wufootest.NewServer("WXYZ-WXYZ-WXYZ-WXYZ")
+	defer server.Close()
+	server.SetCount("applicants", 17)
+
+	status, body := get(t, setupTest(t, server, "applicants"), "/")
+	if status != 401 || body["code"] != wufoo.ErrAuthFailed || body["account"] != "railsgirlssb" {
+		t.Errorf("expected 401 %s, got %d %v", wufoo.ErrAuthFailed, status, body)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants", "coaches"}}},
+		Counters: []CounterConfig{
+			{Name: "applicants", Forms: []string{"applicants"}, Capacity: 40},
+			{Name: "berlin", Forms: []string{"applicants", "coaches"}, Filters: []string{"Field3 Is_equal_to Berlin"}},
+		},
+		Retry: RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetCount("applicants", 30)
+	fake.SetCount("coaches", 5)
+	fake.Entries["applicants"] = []wufoo.Entry{{"Field3": "Berlin"}, {"Field3": "Hamburg"}, {"Field3": "Berlin"}}
+
+	status, body := get(t, cache, "/counters/applicants")
+	if status != 200 || body["count"] != 30.0 || body["remaining"] != 10.0 {
+		t.Errorf("expected 30 applicants with 10 seats left, got %d %v", status, body)
+	}
+	if status, body := get(t, cache, "/counters/berlin"); status != 200 || body["count"] != 2.0 {
+		t.Errorf("expected 2 entries from Berlin, got %d %v", status, body)
+	}
+	if status, body := get(t, cache, "/"); status != 200 || body["count"] != 35.0 {
+		t.Errorf("expected filtered counts to be left out of the total, got %d %v", status, body)
+	}
+	if status, _ := get(t, cache, "/counters/nope"); status != 404 {
+		t.Errorf("expected 404 for an unknown counter, got %d", status)
+	}
+}
+
+func TestForms(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants", "coaches"}, Capacities: map[string]int{"applicants": 40}}},
+		Retry:    RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetCount("applicants", 30)
+	fake.SetCount("coaches", 5)
+
+	status, body := get(t, cache, "/forms")
+	forms, _ := body["forms"].([]interface{})
+	if status != 200 || len(forms) != 2 {
+		t.Fatalf("expected 200 with 2 forms, got %d %v", status, body)
+	}
+	applicants, coaches := forms[0].(map[string]interface{}), forms[1].(map[string]interface{})
+	if applicants["account"] != "railsgirlssb" || applicants["form_id"] != "applicants" || applicants["count"] != 30.0 || applicants["remaining"] != 10.0 {
+		t.Errorf("expected 30 applicants with 10 seats left, got %v", applicants)
+	}
+	if coaches["form_id"] != "coaches" || coaches["count"] != 5.0 {
+		t.Errorf("expected 5 coaches, got %v", coaches)
+	}
+	if _, ok := coaches["capacity"]; ok {
+		t.Errorf("expected no capacity for coaches, got %v", coaches)
+	}
+
+	if status, body := get(t, cache, "/forms?account=nope"); status != 200 || len(body["forms"].([]interface{})) != 0 {
+		t.Errorf("expected no forms for an unknown account, got %d %v", status, body)
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{
+			{Account: "railsgirlssb", FormIds: []string{"applicants", "coaches"}},
+			{Account: "railsgirlsberlin", FormIds: []string{"berlin"}},
+		},
+		Retry: RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetCount("applicants", 30)
+	fake.SetCount("coaches", 5)
+	fake.SetCount("berlin", 12)
+
+	status, body := get(t, cache, "/accounts")
+	accounts, _ := body["accounts"].([]interface{})
+	if status != 200 || body["count"] != 47.0 || len(accounts) != 2 {
+		t.Fatalf("expected 200 with 47 entries in 2 accounts, got %d %v", status, body)
+	}
+	for i, expected := range []struct {
+		account string
+		count   float64
+	}{
+		{"railsgirlssb", 35},
+		{"railsgirlsberlin", 12},
+	} {
+		account := accounts[i].(map[string]interface{})
+		if account["account"] != expected.account || account["count"] != expected.count {
+			t.Errorf("expected %s with %v entries, got %v", expected.account, expected.count, account)
+		}
+	}
+
+	if status, body := get(t, cache, "/accounts/railsgirlsberlin"); status != 200 || body["account"] != "railsgirlsberlin" || body["count"] != 12.0 {
+		t.Errorf("expected 12 entries for railsgirlsberlin, got %d %v", status, body)
+	}
+	if status, body := get(t, cache, "/forms?account=railsgirlsberlin"); status != 200 || len(body["forms"].([]interface{})) != 1 {
+		t.Errorf("expected only the form of railsgirlsberlin, got %d %v", status, body)
+	}
+	if status, body := get(t, cache, "/accounts/nope"); status != 404 || body["error"] != "unknown account" {
+		t.Errorf("expected 404 for an unknown account, got %d %v", status, body)
+	}
+}
+
+func TestAccountsError(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		Retry:    RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetError("applicants", &wufoo.Error{Code: wufoo.ErrAuthFailed, Err: errors.New("unexpected status 401")})
+
+	for _, path := range []string{"/accounts", "/accounts/railsgirlssb"} {
+		if status, body := get(t, cache, path); status != 401 || body["code"] != wufoo.ErrAuthFailed {
+			t.Errorf("%s: expected the error of the failed fetch, got %d %v", path, status, body)
+		}
+	}
+}
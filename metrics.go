@@ -0,0 +1,170 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/go-martini/martini"
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the upstream latency histogram.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics collects what /metrics reports in the Prometheus text format.
+type metrics struct {
+	sync.Mutex
+
+	requests     map[string]int
+	latency      []int
+	latencySum   float64
+	latencyCount int
+	cacheLookups map[string]int
+	webhooks     map[string]int
+	httpRequests map[[2]string]int
+}
+
+var appMetrics = newMetrics()
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:     map[string]int{},
+		latency:      make([]int, len(latencyBuckets)),
+		cacheLookups: map[string]int{},
+		webhooks:     map[string]int{},
+		httpRequests: map[[2]string]int{},
+	}
+}
+
+// observeFetch records the outcome and duration of one Wufoo request.
+func (m *metrics) observeFetch(duration time.Duration, err error) {
+	outcome := "ok"
+	if errors.Is(err, context.Canceled) {
+		outcome = "canceled"
+	} else if we, ok := err.(*wufoo.Error); ok {
+		outcome = we.Code
+	} else if err != nil {
+		outcome = wufoo.ErrUpstream
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.requests[outcome]++
+	seconds := duration.Seconds()
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			m.latency[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+// observeCache records a cache lookup as "hit", "stale" or "miss".
+func (m *metrics) observeCache(result string) {
+	m.Lock()
+	m.cacheLookups[result]++
+	m.Unlock()
+}
+
+// observeWebhook records a webhook call as "counted", "duplicate",
+// "unauthorized", "unknown_form" or "not_cached".
+func (m *metrics) observeWebhook(result string) {
+	m.Lock()
+	m.webhooks[result]++
+	m.Unlock()
+}
+
+// Handler is a martini middleware counting the responses of this app.
+func (m *metrics) Handler(res http.ResponseWriter, req *http.Request, c martini.Context) {
+	c.Next()
+	status := "unknown"
+	if rw, ok := res.(martini.ResponseWriter); ok {
+		status = strconv.Itoa(rw.Status())
+	}
+
+	m.Lock()
+	m.httpRequests[[2]string{req.Method, status}]++
+	m.Unlock()
+}
+
+// Write renders all metrics together with the latest count of every form.
+func (m *metrics) Write(w http.ResponseWriter, forms []FormCount) {
+	var buf bytes.Buffer
+
+	writeHeader(&buf, "wufoo_form_entries", "gauge", "Latest entry count per form.")
+	for _, form := range forms {
+		if !form.FetchedAt.IsZero() && len(form.Counter) < 1 {
+			fmt.Fprintf(&buf, "wufoo_form_entries{account=%s,form=%s} %d\n", label(form.Account), label(form.FormId), form.EntryCount)
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	writeHeader(&buf, "wufoo_requests_total", "counter", "Wufoo API requests by outcome.")
+	for _, outcome := range sortedKeys(m.requests) {
+		fmt.Fprintf(&buf, "wufoo_requests_total{outcome=%s} %d\n", label(outcome), m.requests[outcome])
+	}
+
+	writeHeader(&buf, "wufoo_request_duration_seconds", "histogram", "Latency of Wufoo API requests.")
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(&buf, "wufoo_request_duration_seconds_bucket{le=\"%g\"} %d\n", le, m.latency[i])
+	}
+	fmt.Fprintf(&buf, "wufoo_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(&buf, "wufoo_request_duration_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(&buf, "wufoo_request_duration_seconds_count %d\n", m.latencyCount)
+
+	writeHeader(&buf, "wufoo_cache_lookups_total", "counter", "Cache lookups by result (hit, stale or miss).")
+	for _, result := range sortedKeys(m.cacheLookups) {
+		fmt.Fprintf(&buf, "wufoo_cache_lookups_total{result=%s} %d\n", label(result), m.cacheLookups[result])
+	}
+
+	writeHeader(&buf, "wufoo_webhooks_total", "counter", "Webhook calls by result.")
+	for _, result := range sortedKeys(m.webhooks) {
+		fmt.Fprintf(&buf, "wufoo_webhooks_total{result=%s} %d\n", label(result), m.webhooks[result])
+	}
+
+	writeHeader(&buf, "http_requests_total", "counter", "HTTP responses of this app by method and status.")
+	var keys [][2]string
+	for key := range m.httpRequests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0]+" "+keys[i][1] < keys[j][0]+" "+keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "http_requests_total{method=%s,status=%s} %d\n", label(key[0]), label(key[1]), m.httpRequests[key])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(200)
+	w.Write(buf.Bytes())
+}
+
+func writeHeader(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
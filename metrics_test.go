@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	appMetrics = newMetrics()
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		Retry:    RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetCount("applicants", 30)
+	get(t, cache, "/")
+	get(t, cache, "/")
+	appMetrics.observeFetch(250*time.Millisecond, nil)
+	appMetrics.observeFetch(3*time.Second, &wufoo.Error{Code: wufoo.ErrAuthFailed, Err: errors.New("unexpected status 401")})
+	appMetrics.observeFetch(time.Minute, context.Canceled)
+	appMetrics.observeWebhook("duplicate")
+
+	res := fetchPage(cache, "/metrics")
+	if res.Code != 200 || res.Header().Get("Content-Type") != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("expected 200 in the Prometheus text format, got %d %s", res.Code, res.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(res.Body.String(), "\n")
+	for _, expected := range []string{
+		"# HELP wufoo_form_entries Latest entry count per form.",
+		"# TYPE wufoo_form_entries gauge",
+		`wufoo_form_entries{account="railsgirlssb",form="applicants"} 30`,
+		"# TYPE wufoo_requests_total counter",
+		`wufoo_requests_total{outcome="auth_failed"} 1`,
+		`wufoo_requests_total{outcome="canceled"} 1`,
+		`wufoo_requests_total{outcome="ok"} 1`,
+		"# TYPE wufoo_request_duration_seconds histogram",
+		`wufoo_request_duration_seconds_bucket{le="0.1"} 0`,
+		`wufoo_request_duration_seconds_bucket{le="0.25"} 1`,
+		`wufoo_request_duration_seconds_bucket{le="5"} 2`,
+		`wufoo_request_duration_seconds_bucket{le="10"} 2`,
+		`wufoo_request_duration_seconds_bucket{le="+Inf"} 3`,
+		"wufoo_request_duration_seconds_sum 63.25",
+		"wufoo_request_duration_seconds_count 3",
+		`wufoo_cache_lookups_total{result="hit"} 1`,
+		`wufoo_cache_lookups_total{result="miss"} 1`,
+		`wufoo_webhooks_total{result="duplicate"} 1`,
+		`http_requests_total{method="GET",status="200"} 2`,
+	} {
+		if !contains(lines, expected) {
+			t.Errorf("expected the line %q, got\n%s", expected, res.Body.String())
+		}
+	}
+}
+
+func TestMetricsLabel(t *testing.T) {
+	tests := []struct {
+		value, label string
+	}{
+		{"applicants", `"applicants"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\forms`, `"C:\\forms"`},
+		{"two\nlines", `"two\nlines"`},
+	}
+	for _, test := range tests {
+		if label := label(test.value); label != test.label {
+			t.Errorf("%q: expected %s, got %s", test.value, test.label, label)
+		}
+	}
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
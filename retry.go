@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides how often and how long to wait before a failed Wufoo
+// request is repeated. The delay doubles with every attempt up to MaxDelay,
+// and Jitter (0 to 1) is the fraction of it that is randomized.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// Do calls fetch until it succeeds, fails permanently, MaxAttempts is
+// reached or ctx is cancelled.
+func (p RetryPolicy) Do(ctx context.Context, form formRef, fetch func() (int, error)) (int, error) {
+	for attempt := 1; ; attempt++ {
+		entryCount, err := fetch()
+		we, ok := err.(*wufoo.Error)
+		if err == nil || !ok || !we.Retryable || attempt >= p.MaxAttempts {
+			return entryCount, err
+		}
+
+		delay := p.delay(attempt)
+		if we.RetryAfter > 0 {
+			if we.RetryAfter > p.MaxDelay {
+				log.Printf("%s form %s: not retrying, Wufoo asked to wait %s", form.Account.Account, form.FormId, we.RetryAfter)
+				return entryCount, err
+			}
+			delay = we.RetryAfter
+		}
+		log.Printf("%s form %s: attempt %d/%d failed, retrying in %s: %s",
+			form.Account.Account, form.FormId, attempt, p.MaxAttempts, delay, we.Err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return entryCount, err
+		}
+	}
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay << uint(attempt-1)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	return delay - time.Duration(p.Jitter*rand.Float64()*float64(delay))
+}
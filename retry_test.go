@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{70, time.Second},
+	}
+	for _, test := range tests {
+		if delay := policy.delay(test.attempt); delay != test.delay {
+			t.Errorf("attempt %d: expected %s, got %s", test.attempt, test.delay, delay)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.delay(2); delay < 100*time.Millisecond || delay > 200*time.Millisecond {
+			t.Fatalf("expected a jittered delay between 100ms and 200ms, got %s", delay)
+		}
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	form := formRef{Account: AccountConfig{Account: "railsgirlssb"}, FormId: "applicants"}
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"success", nil, 1},
+		{"permanent", &wufoo.Error{Code: wufoo.ErrAuthFailed, Err: errors.New("unexpected status 401")}, 1},
+		{"other", errors.New("boom"), 1},
+		{"retryable", &wufoo.Error{Code: wufoo.ErrUpstream, Err: errors.New("unexpected status 500"), Retryable: true}, 3},
+		{"retry after", &wufoo.Error{Code: wufoo.ErrRateLimited, Err: errors.New("unexpected status 429"), Retryable: true, RetryAfter: 5 * time.Millisecond}, 3},
+		{"retry after too long", &wufoo.Error{Code: wufoo.ErrRateLimited, Err: errors.New("unexpected status 429"), Retryable: true, RetryAfter: time.Minute}, 1},
+	}
+	for _, test := range tests {
+		attempts := 0
+		_, err := policy.Do(context.Background(), form, func() (int, error) {
+			attempts++
+			return 0, test.err
+		})
+		if err != test.err || attempts != test.attempts {
+			t.Errorf("%s: expected %d attempts, got %d with %v", test.name, test.attempts, attempts, err)
+		}
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	policy.Do(ctx, formRef{FormId: "applicants"}, func() (int, error) {
+		attempts++
+		cancel()
+		return 0, &wufoo.Error{Code: wufoo.ErrUpstream, Err: errors.New("unexpected status 500"), Retryable: true}
+	})
+	if attempts != 1 {
+		t.Errorf("expected no retry after cancelling, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	bolt "github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/go.etcd.io/bbolt"
+
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// snapshot holds the counts of all forms after one successful refresh.
+type snapshot struct {
+	Time  time.Time   `json:"time"`
+	Forms []FormCount `json:"forms"`
+}
+
+// historyStore persists the history across restarts.
+type historyStore interface {
+	// AppendHour adds an hour that has ended.
+	AppendHour(hour historyHour) error
+	// SaveCurrent replaces the hour in progress and the latest snapshot.
+	SaveCurrent(hour historyHour, latest snapshot) error
+	// Load returns the hours that started after since, oldest first and
+	// including the one in progress, and the latest snapshot if there is one.
+	Load(since time.Time) ([]historyHour, *snapshot, error)
+	// Compact drops the hours that started before since.
+	Compact(since time.Time) error
+}
+
+var (
+	hoursBucket  = []byte("hours")
+	latestBucket = []byte("latest")
+	latestKey    = []byte("snapshot")
+)
+
+// boltStore keeps the history in a BoltDB file. Every hour is stored as
+// JSON under its start time, so the hour in progress is simply overwritten
+// on every refresh, together with the latest snapshot in a second bucket.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// newBoltStore opens or creates the database at path. It fails if another
+// process holds it open for more than a second.
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %s", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{hoursBucket, latestBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %s", path, err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// hourKey orders the hours by their start time.
+func hourKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	if t.Unix() > 0 {
+		binary.BigEndian.PutUint64(key, uint64(t.Unix()))
+	}
+	return key
+}
+
+func putHour(tx *bolt.Tx, hour historyHour) error {
+	value, err := json.Marshal(hour)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(hoursBucket).Put(hourKey(hour.Time), value)
+}
+
+func (s *boltStore) AppendHour(hour historyHour) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putHour(tx, hour)
+	})
+}
+
+func (s *boltStore) SaveCurrent(hour historyHour, latest snapshot) error {
+	value, err := json.Marshal(latest)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putHour(tx, hour); err != nil {
+			return err
+		}
+		return tx.Bucket(latestBucket).Put(latestKey, value)
+	})
+}
+
+// Load skips values it can't parse, so that a change of their layout
+// doesn't keep the app from starting.
+func (s *boltStore) Load(since time.Time) ([]historyHour, *snapshot, error) {
+	var hours []historyHour
+	var latest *snapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(hoursBucket).Cursor()
+		for k, v := c.Seek(hourKey(since)); k != nil; k, v = c.Next() {
+			var hour historyHour
+			if err := json.Unmarshal(v, &hour); err != nil {
+				log.Printf("skipping unreadable hour %x: %s", k, err)
+				continue
+			}
+			if hour.Time.After(since) {
+				hours = append(hours, hour)
+			}
+		}
+
+		if v := tx.Bucket(latestBucket).Get(latestKey); v != nil {
+			latest = &snapshot{}
+			if err := json.Unmarshal(v, latest); err != nil {
+				log.Printf("skipping unreadable latest counts: %s", err)
+				latest = nil
+			}
+		}
+		return nil
+	})
+	return hours, latest, err
+}
+
+// Compact deletes the hours that started before since. BoltDB reuses the
+// pages they took up, so the file stops growing once retention is reached.
+func (s *boltStore) Compact(since time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(hoursBucket)
+		var expired [][]byte
+		end := hourKey(since)
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openStore opens a store at path that is closed after the test.
+func openStore(t *testing.T, path string) *boltStore {
+	store, err := newBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counts.db")
+	store := openStore(t, path)
+	start := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		hour := historyHour{Time: start.Add(time.Duration(i) * time.Hour), Forms: []hourCount{{"railsgirlssb", "applicants", i, i + 1}}}
+		if err := store.AppendHour(hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hours, latest, err := store.Load(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hours) != 2 || hours[1].Forms[0].Last != 3 || latest != nil {
+		t.Errorf("expected the last two hours, got %v %v", hours, latest)
+	}
+
+	current := historyHour{Time: start.Add(2 * time.Hour), Forms: []hourCount{{"railsgirlssb", "applicants", 2, 4}}}
+	snap := snapshot{Time: start.Add(150 * time.Minute), Forms: []FormCount{{Account: "railsgirlssb", FormId: "applicants", EntryCount: 4}}}
+	if err := store.SaveCurrent(current, snap); err != nil {
+		t.Fatal(err)
+	}
+	hours, latest, _ = store.Load(start)
+	if len(hours) != 2 || hours[1].Forms[0].Last != 4 || latest == nil || latest.Forms[0].EntryCount != 4 {
+		t.Errorf("expected the hour in progress to replace the appended one, got %v %v", hours, latest)
+	}
+
+	if err := store.Compact(start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if hours, _, _ := store.Load(time.Time{}); len(hours) != 2 || !hours[0].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected two hours after compacting, got %v", hours)
+	}
+
+	store.Close()
+	hours, latest, err = openStore(t, path).Load(time.Time{})
+	if err != nil || len(hours) != 2 || latest == nil || !latest.Time.Equal(snap.Time) {
+		t.Errorf("expected the hours and the snapshot to survive reopening, got %v %v %v", hours, latest, err)
+	}
+}
+
+func TestBoltStoreSavesHours(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "counts.db"))
+	h := newHistory(24*time.Hour, store)
+	start := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+	h.compactedAt = start
+	for i := 0; i < 150; i++ {
+		h.record(start.Add(time.Duration(i)*time.Minute), []FormCount{{Account: "railsgirlssb", FormId: "applicants", EntryCount: i}})
+	}
+
+	hours, _, _ := store.Load(time.Time{})
+	if len(hours) != 3 || hours[0].Forms[0].Last != 59 || hours[1].Forms[0].Last != 119 || hours[2].Forms[0].Last != 149 {
+		t.Errorf("expected one value per hour, got %v", hours)
+	}
+}
+
+func TestHistoryRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counts.db")
+	now := time.Now()
+	forms := []FormCount{{Account: "railsgirlssb", FormId: "applicants", EntryCount: 28, FetchedAt: now}}
+	store := openStore(t, path)
+	newHistory(time.Hour, store).record(now, forms)
+	store.Close()
+
+	h := newHistory(time.Hour, openStore(t, path))
+	if err := h.load(now); err != nil {
+		t.Fatal(err)
+	}
+	last, ok := h.last()
+	if !ok {
+		t.Fatal("expected the saved snapshot to be loaded")
+	}
+	if points := h.series(nil, time.Hour); len(points) != 1 || points[0].Count != 28 {
+		t.Errorf("expected the saved hour to be loaded, got %v", points)
+	}
+
+	cache := newCountCache(time.Minute, time.Minute, []formRef{{Account: AccountConfig{Account: "railsgirlssb"}, FormId: "applicants"}}, nil)
+	if !cache.restore(last) {
+		t.Fatal("expected the cache to be restored")
+	}
+	if forms := cache.Snapshot(); forms[0].EntryCount != 28 || !forms[0].FetchedAt.Equal(now) {
+		t.Errorf("expected the saved count, got %v", forms)
+	}
+	if !cache.Stale() {
+		t.Error("expected the restored counts to be stale")
+	}
+	if ready, body := readiness(cache, time.Hour); ready {
+		t.Errorf("expected not to be ready before fetching from Wufoo, got %v", body)
+	}
+
+	other := newCountCache(time.Minute, time.Minute, []formRef{{Account: AccountConfig{Account: "railsgirlssb"}, FormId: "coaches"}}, nil)
+	if other.restore(last) {
+		t.Error("expected a snapshot without the configured forms not to be restored")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// heartbeatInterval is how often an idle stream sends a comment, so proxies
+// and browsers keep the connection open.
+const heartbeatInterval = 15 * time.Second
+
+// streamHandler sends the current total and then every change as
+// Server-Sent Events until the client goes away.
+func streamHandler(cache *countCache) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		events := countChanges.subscribe()
+		defer countChanges.unsubscribe(events)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(200)
+
+		id := 0
+		send := func(event changeEvent) {
+			data, _ := json.Marshal(event)
+			id++
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, data)
+			flusher.Flush()
+		}
+		if forms, err := cache.Forms(req.Context(), nil); err == nil {
+			event, _ := changes(nil, forms)
+			send(event)
+		} else {
+			fmt.Fprintf(w, ": %s\n\n", err)
+			flusher.Flush()
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					// Dropped for falling behind, the client reconnects.
+					return
+				}
+				send(event)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent returns the data of the next event on stream, skipping comments.
+func readEvent(t *testing.T, stream *bufio.Reader) changeEvent {
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			var event changeEvent
+			if err := json.Unmarshal([]byte(line[len("data: "):]), &event); err != nil {
+				t.Fatal(err)
+			}
+			return event
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants", "coaches"}}},
+		CacheTTL: time.Millisecond,
+		Retry:    RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetCount("applicants", 30)
+	fake.SetCount("coaches", 5)
+
+	server := httptest.NewServer(newServer(cache))
+	defer server.Close()
+	res, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+	stream := bufio.NewReader(res.Body)
+
+	if event := readEvent(t, stream); event.Count != 35 || len(event.Forms) != 2 {
+		t.Errorf("expected the current counts first, got %v", event)
+	}
+
+	fake.SetCount("coaches", 6)
+	<-cache.refresh(true).done
+	// The first fetch, made for this stream, may be published as well.
+	event := readEvent(t, stream)
+	for event.Count == 35 {
+		event = readEvent(t, stream)
+	}
+	if event.Count != 36 || len(event.Forms) != 1 || event.Forms[0].FormId != "coaches" {
+		t.Errorf("expected only coaches to have changed, got %v", event)
+	}
+}
+
+func TestChangeHubDropsSlowSubscribers(t *testing.T) {
+	hub := newChangeHub()
+	slow := hub.subscribe()
+	for i := 0; i <= subscriberBuffer; i++ {
+		hub.publish(changeEvent{Count: i})
+	}
+	for range slow {
+	}
+	if len(hub.subscribers) != 0 {
+		t.Error("expected the slow subscriber to be dropped")
+	}
+	hub.unsubscribe(slow)
+}
@@ -0,0 +1,62 @@
+/* Wufoo count widget, see https://github.com/railsgirlssb/wufoo-count-app
+ *
+ * <script src="https://<app>/widget.js?counter=applicants&label=Applicants&theme=dark&style=bar" async></script>
+ *
+ * Renders the counter right after the script tag and keeps it up to date. */
+(function () {
+  var script = document.currentScript;
+  if (!script) return;
+  var src = new URL(script.src);
+  var base = src.origin + src.pathname.replace(/\/widget\.js$/, "");
+  var params = src.searchParams;
+  var counter = params.get("counter");
+  var theme = {{json .Themes}}.indexOf(params.get("theme")) >= 0 ? params.get("theme") : "light";
+  var style = {{json .Styles}}.indexOf(params.get("style")) >= 0 ? params.get("style") : "number";
+  var source = base + (counter ? "/counters/" + encodeURIComponent(counter) : "/");
+
+  if (!document.getElementById("wufoo-counter-style")) {
+    var css = document.createElement("style");
+    css.id = "wufoo-counter-style";
+    css.textContent = {{json .CSS}};
+    document.head.appendChild(css);
+  }
+
+  var root = document.createElement("div");
+  root.className = "wufoo-counter " + theme + " " + style;
+  var count = document.createElement("span");
+  count.className = "count";
+  count.textContent = "–";
+  var label = document.createElement("span");
+  label.className = "label";
+  label.textContent = params.get("label") || counter || {{json .DefaultLabel}};
+  root.appendChild(count);
+  root.appendChild(label);
+  script.parentNode.insertBefore(root, script.nextSibling);
+
+  function update(body) {
+    if (typeof body.count !== "number") return;
+    count.textContent = body.count;
+    if (!body.capacity) return;
+    if (style === "bar" && !root.querySelector(".bar")) {
+      var bar = document.createElement("div");
+      bar.className = "bar";
+      bar.appendChild(document.createElement("div")).className = "fill";
+      root.appendChild(bar);
+    }
+    var fill = root.querySelector(".fill");
+    if (fill) fill.style.width = Math.min(body.percent_full, 100) + "%";
+    var remaining = root.querySelector(".remaining") || root.appendChild(document.createElement("span"));
+    remaining.className = "remaining";
+    remaining.textContent = body.full ? "fully booked" : body.remaining + " of " + body.capacity + " seats left";
+  }
+  function load() {
+    fetch(source).then(function (res) { return res.json(); }).then(update);
+  }
+
+  load();
+  if (window.EventSource) {
+    new EventSource(base + "/stream").onmessage = load;
+  } else {
+    setInterval(load, 60000);
+  }
+})();
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/martini-contrib/render"
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// webhookHandler receives the entries Wufoo posts for forms whose webhook
+// points at /webhooks/wufoo and counts them right away. The form is taken
+// from the form structure Wufoo sends along ("Include Metadata" must be
+// checked), or from ?form= in the webhook URL.
+func webhookHandler(cache *countCache) func(render.Render, *http.Request) {
+	return func(r render.Render, req *http.Request) {
+		if len(wufooConfig.WebhookKey) < 1 {
+			r.JSON(404, map[string]interface{}{"error": "webhooks are disabled, set WUFOO_WEBHOOK_KEY"})
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			r.JSON(400, map[string]interface{}{"error": "invalid form data"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(req.PostForm.Get("HandshakeKey")), []byte(wufooConfig.WebhookKey)) != 1 {
+			appMetrics.observeWebhook("unauthorized")
+			r.JSON(403, map[string]interface{}{"error": "invalid handshake key"})
+			return
+		}
+
+		form := req.URL.Query().Get("form")
+		if len(form) < 1 {
+			var structure struct {
+				Hash string
+			}
+			json.Unmarshal([]byte(req.PostForm.Get("FormStructure")), &structure)
+			form = structure.Hash
+		}
+		if account := req.URL.Query().Get("account"); len(account) > 0 {
+			form = account + "/" + form
+		}
+		key, err := wufooConfig.resolveForm(form)
+		if err != nil {
+			appMetrics.observeWebhook("unknown_form")
+			r.JSON(404, map[string]interface{}{"error": "unknown form", "message": err.Error()})
+			return
+		}
+
+		entry := wufoo.Entry{}
+		for field := range req.PostForm {
+			entry[field] = req.PostForm.Get(field)
+		}
+		matches := func(name string) bool {
+			counter, _ := wufooConfig.Counter(name)
+			filter, _ := counter.filter(time.Now())
+			return filter.Matches(entry)
+		}
+
+		body := map[string]interface{}{"account": key.Account, "form_id": key.FormId}
+		count, result := cache.push(key, req.PostForm.Get("EntryId"), matches)
+		appMetrics.observeWebhook(result)
+		if result == "counted" {
+			body["count"] = count
+		}
+		body["result"] = result
+		r.JSON(200, body)
+	}
+}
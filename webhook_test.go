@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func postWebhook(cache *countCache, query string, form url.Values) int {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/webhooks/wufoo"+query, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	newServer(cache).ServeHTTP(res, req)
+	return res.Code
+}
+
+func TestWebhook(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts:   []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		Counters:   []CounterConfig{{Name: "berlin", Forms: []string{"applicants"}, Filters: []string{"Field3 Is_equal_to Berlin"}}},
+		Retry:      RetryPolicy{MaxAttempts: 1},
+		WebhookKey: "secret",
+	})
+	fake.SetCount("applicants", 30)
+
+	entry := url.Values{
+		"HandshakeKey":  {"secret"},
+		"EntryId":       {"31"},
+		"Field3":        {"Berlin"},
+		"FormStructure": {`{"Name":"Applicants","Hash":"applicants"}`},
+	}
+	if status := postWebhook(cache, "", entry); status != 200 {
+		t.Errorf("expected 200 before the first fetch, got %d", status)
+	}
+	get(t, cache, "/")
+
+	if status := postWebhook(cache, "", entry); status != 200 {
+		t.Errorf("expected 200, got %d", status)
+	}
+	postWebhook(cache, "", entry)
+	if _, body := get(t, cache, "/"); body["count"] != 31.0 {
+		t.Errorf("expected the pushed entry to be counted once, got %v", body)
+	}
+	if _, body := get(t, cache, "/counters/berlin"); body["count"] != 1.0 {
+		t.Errorf("expected the pushed entry to match the filter, got %v", body)
+	}
+
+	entry.Set("HandshakeKey", "wrong")
+	if status := postWebhook(cache, "", entry); status != 403 {
+		t.Errorf("expected 403 for a wrong handshake key, got %d", status)
+	}
+	entry.Set("HandshakeKey", "secret")
+	if status := postWebhook(cache, "?form=unknown", entry); status != 404 {
+		t.Errorf("expected 404 for an unknown form, got %d", status)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/github.com/martini-contrib/render"
+	"github.com/railsgirlssb/wufoo-count-app/wufoo"
+
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	texttemplate "text/template"
+)
+
+// widgetCSS styles the counter of /embed and /widget.js.
+const widgetCSS = `.wufoo-counter { display: inline-block; padding: 12px 16px; border-radius: 6px; text-align: center; min-width: 160px; font-family: "Helvetica Neue", Helvetica, Arial, sans-serif; }
+.wufoo-counter.light { background: #fff; color: #333; border: 1px solid #e5e5e5; }
+.wufoo-counter.dark { background: #333; color: #fff; }
+.wufoo-counter.pink { background: #e4007c; color: #fff; }
+.wufoo-counter .count { display: block; font-size: 36px; font-weight: bold; line-height: 1.2; }
+.wufoo-counter .label, .wufoo-counter .remaining { display: block; font-size: 14px; }
+.wufoo-counter .bar { height: 8px; margin: 8px 0 4px; border-radius: 4px; background: rgba(128, 128, 128, 0.3); overflow: hidden; }
+.wufoo-counter .fill { height: 100%; background: currentColor; }`
+
+var (
+	widgetThemes = []string{"light", "dark", "pink"}
+	widgetStyles = []string{"number", "bar"}
+)
+
+// embedPage is what templates/embed.tmpl renders.
+type embedPage struct {
+	Label    string
+	Theme    string
+	Style    string
+	Count    int
+	Capacity *Capacity
+	Error    string
+	Source   string
+	CSS      template.CSS
+}
+
+// embedHandler renders a standalone page with the total, or the counter
+// named by ?counter=, meant to be put into an iframe.
+func embedHandler(cache *countCache) func(render.Render, *http.Request) {
+	return func(r render.Render, req *http.Request) {
+		query := req.URL.Query()
+		page := embedPage{
+			Label:  query.Get("label"),
+			Theme:  oneOf(query.Get("theme"), widgetThemes),
+			Style:  oneOf(query.Get("style"), widgetStyles),
+			Source: "/",
+			CSS:    template.CSS(widgetCSS),
+		}
+
+		var selector formSelector
+		capacity := wufooConfig.Capacity
+		if name := query.Get("counter"); len(name) > 0 {
+			counter, ok := wufooConfig.Counter(name)
+			if !ok {
+				r.Text(404, "unknown counter")
+				return
+			}
+			selector, capacity = wufooConfig.counterSelector(counter), counter.Capacity
+			page.Source = "/counters/" + counter.Name
+			if len(page.Label) < 1 {
+				page.Label = counter.Name
+			}
+		}
+		if len(page.Label) < 1 {
+			page.Label = "Signups"
+		}
+
+		status := 200
+		count, err := cache.Get(req.Context(), selector)
+		if err != nil {
+			page.Error, status = wufoo.ErrUpstream, errorStatus(wufoo.ErrUpstream)
+			if we, ok := err.(*wufoo.Error); ok {
+				page.Error, status = we.Code, errorStatus(we.Code)
+			}
+		} else {
+			page.Count, page.Capacity = count, newCapacity(count, capacity)
+		}
+		r.HTML(status, "embed", page)
+	}
+}
+
+// widgetHandler serves the script that renders a counter into any page.
+// templates/widget.js is JavaScript, so it is a text template whose values
+// are encoded with json. It is parsed up front like the HTML templates.
+func widgetHandler() func(http.ResponseWriter) {
+	script := texttemplate.Must(texttemplate.New("widget.js").Funcs(texttemplate.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			encoded, err := json.Marshal(v)
+			return string(encoded), err
+		},
+	}).ParseFiles("templates/widget.js"))
+	data := map[string]interface{}{
+		"Themes":       widgetThemes,
+		"Styles":       widgetStyles,
+		"CSS":          widgetCSS,
+		"DefaultLabel": "Signups",
+	}
+
+	return func(w http.ResponseWriter) {
+		var buf bytes.Buffer
+		if err := script.Execute(&buf, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/javascript; charset=UTF-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(buf.Bytes())
+	}
+}
+
+// oneOf returns value if it is one of choices, and the first choice otherwise.
+func oneOf(value string, choices []string) string {
+	for _, choice := range choices {
+		if value == choice {
+			return value
+		}
+	}
+	return choices[0]
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fetchPage(cache *countCache, path string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	newServer(cache).ServeHTTP(res, req)
+	return res
+}
+
+func TestEmbed(t *testing.T) {
+	fake, cache := setupFake(t, WufooConfig{
+		Accounts: []AccountConfig{{Account: "railsgirlssb", FormIds: []string{"applicants"}}},
+		Counters: []CounterConfig{{Name: "applicants", Forms: []string{"applicants"}, Capacity: 40}},
+		Retry:    RetryPolicy{MaxAttempts: 1},
+	})
+	fake.SetCount("applicants", 30)
+
+	res := fetchPage(cache, "/embed?counter=applicants&style=bar&theme=dark&label=<b>Applicants</b>")
+	body := res.Body.String()
+	if res.Code != 200 || !strings.HasPrefix(res.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("expected an HTML page, got %d %q", res.Code, res.Header().Get("Content-Type"))
+	}
+	for _, expected := range []string{
+		`<span class="count">30</span>`,
+		`&lt;b&gt;Applicants&lt;/b&gt;`,
+		`class="wufoo-counter dark bar"`,
+		`style="width: 75%"`,
+		`10 of 40 seats left`,
+		`var source = "/counters/applicants";`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected the page to contain %s", expected)
+		}
+	}
+
+	if res := fetchPage(cache, "/embed?theme=</style>"); !strings.Contains(res.Body.String(), `class="wufoo-counter light number"`) {
+		t.Error("expected unknown themes to fall back to light")
+	}
+	if res := fetchPage(cache, "/embed?counter=nope"); res.Code != 404 {
+		t.Errorf("expected 404 for an unknown counter, got %d", res.Code)
+	}
+}
+
+func TestWidget(t *testing.T) {
+	res := fetchPage(newCountCache(time.Minute, time.Minute, nil, nil), "/widget.js")
+	if res.Code != 200 || !strings.HasPrefix(res.Header().Get("Content-Type"), "application/javascript") {
+		t.Fatalf("expected a script, got %d %q", res.Code, res.Header().Get("Content-Type"))
+	}
+	if body := res.Body.String(); !strings.Contains(body, `["light","dark","pink"].indexOf`) || strings.Contains(body, "&#34;") {
+		t.Error("expected the themes to be embedded as JSON")
+	}
+}
@@ -0,0 +1,137 @@
+package wufoo
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Fake is an in-memory Client for tests. Forms are known once they have a
+// count, a form description or entries; all others are reported as not found.
+type Fake struct {
+	sync.Mutex
+
+	Account string
+	Counts  map[string]int
+	Forms   map[string]Form
+	Entries map[string][]Entry
+	// Errors are returned instead of any result for the form, or for the
+	// whole account under the empty form ID.
+	Errors map[string]error
+	// Calls counts the requests made per form ID.
+	Calls map[string]int
+}
+
+func NewFake(account string) *Fake {
+	return &Fake{
+		Account: account,
+		Counts:  map[string]int{},
+		Forms:   map[string]Form{},
+		Entries: map[string][]Entry{},
+		Errors:  map[string]error{},
+		Calls:   map[string]int{},
+	}
+}
+
+// SetCount sets the entry count of a form.
+func (f *Fake) SetCount(formId string, count int) {
+	f.Lock()
+	defer f.Unlock()
+	f.Counts[formId] = count
+}
+
+// SetError makes all requests for a form fail with err, or succeed again if err is nil.
+func (f *Fake) SetError(formId string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	if err == nil {
+		delete(f.Errors, formId)
+	} else {
+		f.Errors[formId] = err
+	}
+}
+
+// CountEntries returns the count set for the form. Filtered counts are
+// computed from its entries instead.
+func (f *Fake) CountEntries(ctx context.Context, formId string, filter Filter) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.check(ctx, formId); err != nil {
+		return 0, err
+	}
+	if len(filter.Rules) < 1 {
+		return f.Counts[formId], nil
+	}
+	count := 0
+	for _, entry := range f.Entries[formId] {
+		if filter.Matches(entry) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *Fake) GetForm(ctx context.Context, formId string) (Form, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.check(ctx, formId); err != nil {
+		return Form{}, err
+	}
+	form, ok := f.Forms[formId]
+	if !ok {
+		form = Form{Name: formId, Hash: formId}
+	}
+	return form, nil
+}
+
+func (f *Fake) ListForms(ctx context.Context) ([]Form, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.check(ctx, ""); err != nil {
+		return nil, err
+	}
+	forms := []Form{}
+	for _, form := range f.Forms {
+		forms = append(forms, form)
+	}
+	return forms, nil
+}
+
+func (f *Fake) ListEntries(ctx context.Context, formId string, page Page) ([]Entry, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.check(ctx, formId); err != nil {
+		return nil, err
+	}
+	entries := f.Entries[formId]
+	if page.Start >= len(entries) {
+		return []Entry{}, nil
+	}
+	end := len(entries)
+	if page.Size > 0 && page.Start+page.Size < end {
+		end = page.Start + page.Size
+	}
+	return entries[page.Start:end], nil
+}
+
+// check records a call and returns the error configured for it, if any.
+func (f *Fake) check(ctx context.Context, formId string) error {
+	f.Calls[formId]++
+	if err := ctx.Err(); err != nil {
+		return &Error{Code: ErrUpstream, Account: f.Account, FormId: formId, Err: err, Retryable: true}
+	}
+	if err := f.Errors[""]; err != nil {
+		return err
+	}
+	if err := f.Errors[formId]; err != nil {
+		return err
+	}
+	if _, ok := f.Counts[formId]; formId != "" && !ok {
+		if _, ok := f.Forms[formId]; !ok {
+			if _, ok := f.Entries[formId]; !ok {
+				return &Error{Code: ErrFormNotFound, Account: f.Account, FormId: formId, Err: errors.New("unexpected status 404")}
+			}
+		}
+	}
+	return nil
+}
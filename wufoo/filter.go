@@ -0,0 +1,130 @@
+package wufoo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter restricts the entries that are counted. Each rule is written like
+// "Field3 Is_equal_to Berlin" or "DateCreated Is_after 2015-03-01"; Match is
+// "AND" (the default) or "OR". The zero Filter matches all entries.
+type Filter struct {
+	Rules []string
+	Match string
+}
+
+// operators are the filter operators Wufoo understands.
+var operators = map[string]bool{
+	"Contains":         true,
+	"Does_not_contain": true,
+	"Begins_with":      true,
+	"Ends_with":        true,
+	"Is_less_than":     true,
+	"Is_greater_than":  true,
+	"Is_on":            true,
+	"Is_before":        true,
+	"Is_after":         true,
+	"Is_not_equal_to":  true,
+	"Is_equal_to":      true,
+	"Is_not_NULL":      true,
+}
+
+// Validate reports the first rule that Wufoo wouldn't understand.
+func (f Filter) Validate() error {
+	if f.Match != "" && f.Match != "AND" && f.Match != "OR" {
+		return fmt.Errorf("match %q must be AND or OR", f.Match)
+	}
+	for _, rule := range f.Rules {
+		field, operator, value := splitRule(rule)
+		switch {
+		case len(field) < 1:
+			return fmt.Errorf("filter %q has no field", rule)
+		case !operators[operator]:
+			return fmt.Errorf("filter %q: unknown operator %q", rule, operator)
+		case len(value) < 1 && operator != "Is_not_NULL":
+			return fmt.Errorf("filter %q has no value", rule)
+		}
+	}
+	return nil
+}
+
+// params are the query parameters Wufoo expects for f.
+func (f Filter) params() map[string]string {
+	if len(f.Rules) < 1 {
+		return nil
+	}
+	params := map[string]string{}
+	for i, rule := range f.Rules {
+		field, operator, value := splitRule(rule)
+		params["Filter"+strconv.Itoa(i+1)] = strings.TrimSpace(field + " " + operator + " " + value)
+	}
+	if len(f.Match) > 0 {
+		params["match"] = f.Match
+	}
+	return params
+}
+
+// Matches evaluates f on entry the way Wufoo would, comparing numbers
+// numerically and everything else, dates included, as strings.
+func (f Filter) Matches(entry Entry) bool {
+	if len(f.Rules) < 1 {
+		return true
+	}
+	or := f.Match == "OR"
+	for _, rule := range f.Rules {
+		field, operator, value := splitRule(rule)
+		if matchRule(entry[field], operator, value) == or {
+			return or
+		}
+	}
+	return !or
+}
+
+func splitRule(rule string) (field, operator, value string) {
+	parts := strings.SplitN(strings.TrimSpace(rule), " ", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], strings.TrimSpace(parts[2])
+}
+
+func matchRule(actual, operator, value string) bool {
+	switch operator {
+	case "Contains":
+		return strings.Contains(actual, value)
+	case "Does_not_contain":
+		return !strings.Contains(actual, value)
+	case "Begins_with":
+		return strings.HasPrefix(actual, value)
+	case "Ends_with":
+		return strings.HasSuffix(actual, value)
+	case "Is_less_than", "Is_before":
+		return compare(actual, value) < 0
+	case "Is_greater_than", "Is_after":
+		return compare(actual, value) > 0
+	case "Is_on":
+		return strings.HasPrefix(actual, value)
+	case "Is_not_equal_to":
+		return actual != value
+	case "Is_equal_to":
+		return actual == value
+	case "Is_not_NULL":
+		return len(actual) > 0
+	}
+	return false
+}
+
+func compare(a, b string) int {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	switch {
+	case errX != nil || errY != nil:
+		return strings.Compare(a, b)
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package wufoo
+
+import (
+	"testing"
+)
+
+func TestFilterMatches(t *testing.T) {
+	entry := Entry{"Field3": "Berlin", "Field5": "12", "DateCreated": "2015-03-04 10:00:00"}
+	tests := []struct {
+		filter  Filter
+		matches bool
+	}{
+		{Filter{}, true},
+		{Filter{Rules: []string{"Field3 Is_equal_to Berlin"}}, true},
+		{Filter{Rules: []string{"Field3 Is_equal_to Berlin", "Field5 Is_greater_than 9"}}, true},
+		{Filter{Rules: []string{"Field3 Is_equal_to Berlin", "Field5 Is_greater_than 12"}}, false},
+		{Filter{Rules: []string{"Field3 Is_equal_to Hamburg", "Field5 Is_less_than 13"}, Match: "OR"}, true},
+		{Filter{Rules: []string{"DateCreated Is_after 2015-03-01"}}, true},
+		{Filter{Rules: []string{"DateCreated Is_on 2015-03-04"}}, true},
+		{Filter{Rules: []string{"Field7 Is_not_NULL"}}, false},
+	}
+	for _, test := range tests {
+		if matches := test.filter.Matches(entry); matches != test.matches {
+			t.Errorf("%v: expected %t, got %t", test.filter, test.matches, matches)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	valid := []Filter{
+		{},
+		{Rules: []string{"Field3 Is_equal_to Berlin"}, Match: "AND"},
+		{Rules: []string{"Field7 Is_not_NULL"}},
+	}
+	for _, filter := range valid {
+		if err := filter.Validate(); err != nil {
+			t.Errorf("%v: %s", filter, err)
+		}
+	}
+	invalid := []Filter{
+		{Rules: []string{"Field3 Is_equal_to Berlin"}, Match: "XOR"},
+		{Rules: []string{"Field3 Equals Berlin"}},
+		{Rules: []string{"Field3 Is_equal_to"}},
+	}
+	for _, filter := range invalid {
+		if err := filter.Validate(); err == nil {
+			t.Errorf("%v: expected an error", filter)
+		}
+	}
+}
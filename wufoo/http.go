@@ -0,0 +1,259 @@
+package wufoo
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/Godeps/_workspace/src/gopkg.in/resty.v0"
+
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures the connection to Wufoo.
+type Options struct {
+	Timeout            time.Duration
+	UserAgent          string
+	Proxy              string
+	CAFile             string
+	InsecureSkipVerify bool
+	// BaseURL replaces https://{account}.wufoo.com/api/v3, e.g. to talk to a
+	// proxy, a local stub or another API version. It is used as is.
+	BaseURL string
+
+	// Observe, if set, is called after every request with its duration and error.
+	Observe func(elapsed time.Duration, err error)
+}
+
+// HTTPClient talks to the Wufoo API of one account.
+type HTTPClient struct {
+	account  string
+	apiKey   string
+	password string
+	baseURL  string
+	options  Options
+	tls      *tls.Config
+	observe  func(time.Duration, error)
+}
+
+// requestContexts carries the context of a resty request to attachContext,
+// since resty has no notion of contexts itself.
+var requestContexts sync.Map
+
+// New creates a client for account. Wufoo ignores the password, but
+// requires one to be sent.
+func New(account, apiKey, password string, options Options) (*HTTPClient, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+	if len(options.CAFile) > 0 {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no certificates", options.CAFile)
+		}
+	}
+
+	baseURL := options.BaseURL
+	if len(baseURL) < 1 {
+		baseURL = fmt.Sprintf("https://%s.wufoo.com/api/v3", account)
+	}
+
+	return &HTTPClient{
+		account:  account,
+		apiKey:   apiKey,
+		password: password,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		options:  options,
+		tls:      tlsConfig,
+		observe:  options.Observe,
+	}, nil
+}
+
+// newResty creates the resty client for a single request. resty assigns
+// its transport on every request, so concurrent requests must not share a
+// client.
+func (c *HTTPClient) newResty() *resty.Client {
+	// resty's timeout is a deadline on the whole connection, so connections
+	// must not be reused for later requests.
+	client := resty.New().
+		SetTimeout(c.options.Timeout).
+		SetHeader("Accept", "application/json").
+		SetHeader("Connection", "close").
+		SetTLSClientConfig(c.tls).
+		OnBeforeRequest(attachContext)
+	if len(c.options.UserAgent) > 0 {
+		client.SetHeader("User-Agent", c.options.UserAgent)
+	}
+	if len(c.options.Proxy) > 0 {
+		client.SetProxy(c.options.Proxy)
+	}
+	return client
+}
+
+func (c *HTTPClient) CountEntries(ctx context.Context, formId string, filter Filter) (int, error) {
+	var result struct {
+		EntryCount string
+	}
+	if err := c.get(ctx, formId, "/forms/"+formId+"/entries/count.json", filter.params(), &result); err != nil {
+		return 0, err
+	}
+
+	entryCount, err := strconv.Atoi(result.EntryCount)
+	if err != nil || entryCount < 0 {
+		return 0, c.newError(ErrMalformedResponse, formId, fmt.Errorf("invalid EntryCount %q", result.EntryCount))
+	}
+	return entryCount, nil
+}
+
+func (c *HTTPClient) GetForm(ctx context.Context, formId string) (Form, error) {
+	var result struct {
+		Forms []Form
+	}
+	if err := c.get(ctx, formId, "/forms/"+formId+".json", nil, &result); err != nil {
+		return Form{}, err
+	}
+	if len(result.Forms) != 1 {
+		return Form{}, c.newError(ErrMalformedResponse, formId, fmt.Errorf("expected one form, got %d", len(result.Forms)))
+	}
+	return result.Forms[0], nil
+}
+
+func (c *HTTPClient) ListForms(ctx context.Context) ([]Form, error) {
+	var result struct {
+		Forms []Form
+	}
+	err := c.get(ctx, "", "/forms.json", nil, &result)
+	return result.Forms, err
+}
+
+func (c *HTTPClient) ListEntries(ctx context.Context, formId string, page Page) ([]Entry, error) {
+	params := map[string]string{"pageStart": strconv.Itoa(page.Start)}
+	if page.Size > 0 {
+		params["pageSize"] = strconv.Itoa(page.Size)
+	}
+
+	var result struct {
+		Entries []Entry
+	}
+	err := c.get(ctx, formId, "/forms/"+formId+"/entries.json", params, &result)
+	return result.Entries, err
+}
+
+// get requests path and decodes the JSON response into v. formId is only
+// used to describe errors.
+func (c *HTTPClient) get(ctx context.Context, formId, path string, params map[string]string, v interface{}) (err error) {
+	var resp *resty.Response
+	if c.observe != nil {
+		defer func(start time.Time) {
+			elapsed := time.Since(start)
+			if resp != nil {
+				elapsed = resp.Time()
+			}
+			c.observe(elapsed, err)
+		}(time.Now())
+	}
+
+	req := c.newResty().R().
+		SetBasicAuth(c.apiKey, c.password).
+		SetQueryParams(params)
+	requestContexts.Store(req, ctx)
+	defer requestContexts.Delete(req)
+
+	resp, err = req.Get(c.baseURL + path)
+	if err != nil {
+		return c.transportError(formId, err)
+	}
+	if resp.StatusCode() != 200 {
+		c.logResponse(formId, resp, "unexpected status")
+		return c.statusError(formId, resp)
+	}
+	if contentType := resp.Header().Get("Content-Type"); !resty.IsJSONType(contentType) {
+		c.logResponse(formId, resp, "unexpected content type")
+		return c.newError(ErrMalformedResponse, formId, fmt.Errorf("unexpected content type %q", contentType))
+	}
+	if err := json.Unmarshal(resp.Body, v); err != nil {
+		c.logResponse(formId, resp, "invalid JSON")
+		return c.newError(ErrMalformedResponse, formId, err)
+	}
+	return nil
+}
+
+func attachContext(c *resty.Client, r *resty.Request) error {
+	if ctx, ok := requestContexts.Load(r); ok {
+		r.RawRequest = r.RawRequest.WithContext(ctx.(context.Context))
+	}
+	return nil
+}
+
+func (c *HTTPClient) newError(code, formId string, err error) *Error {
+	return &Error{Code: code, Account: c.account, FormId: formId, Err: err}
+}
+
+// transportError classifies an error returned by resty before any response arrived.
+func (c *HTTPClient) transportError(formId string, err error) *Error {
+	code := ErrUpstream
+	if ne, ok := err.(net.Error); ok && ne.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+		code = ErrUpstreamTimeout
+	}
+	e := c.newError(code, formId, err)
+	e.Retryable = true
+	return e
+}
+
+// statusError classifies a non-200 response.
+func (c *HTTPClient) statusError(formId string, resp *resty.Response) *Error {
+	status := resp.StatusCode()
+	err := fmt.Errorf("unexpected status %d", status)
+
+	var e *Error
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		e = c.newError(ErrAuthFailed, formId, err)
+	case status == http.StatusNotFound:
+		e = c.newError(ErrFormNotFound, formId, err)
+	case status == http.StatusTooManyRequests:
+		e = c.newError(ErrRateLimited, formId, err)
+		e.RetryAfter = parseRetryAfter(resp.Header().Get("Retry-After"))
+	case status == http.StatusGatewayTimeout:
+		e = c.newError(ErrUpstreamTimeout, formId, err)
+	default:
+		e = c.newError(ErrUpstream, formId, err)
+	}
+	e.Retryable = status == http.StatusTooManyRequests || status >= 500
+	return e
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as a date.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// snippetLength limits how much of an unexpected body ends up in the logs.
+const snippetLength = 200
+
+// logResponse logs an unusable response together with the start of its body.
+func (c *HTTPClient) logResponse(formId string, resp *resty.Response, reason string) {
+	snippet := resp.String()
+	if len(snippet) > snippetLength {
+		snippet = snippet[:snippetLength] + "..."
+	}
+	log.Printf("%s form %s: %s (status %d, content type %q): %q",
+		c.account, formId, reason, resp.StatusCode(), resp.Header().Get("Content-Type"), snippet)
+}
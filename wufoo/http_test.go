@@ -0,0 +1,222 @@
+package wufoo
+
+import (
+	"github.com/railsgirlssb/wufoo-count-app/wufoo/wufootest"
+
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testKey = "ABCD-EFGH-IJKL-MNOP"
+
+func newTestClient(t *testing.T, server *wufootest.Server, apiKey string) *HTTPClient {
+	client, err := New("railsgirlssb", apiKey, "any", Options{Timeout: time.Second, BaseURL: server.BaseURL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestCountEntries(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetCount("m1icxbf0bwgo0d", 42)
+
+	count, err := newTestClient(t, server, testKey).CountEntries(context.Background(), "m1icxbf0bwgo0d", Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 42 {
+		t.Errorf("expected 42 entries, got %d", count)
+	}
+}
+
+func TestCountEntriesConcurrently(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetCount("m1icxbf0bwgo0d", 42)
+
+	client := newTestClient(t, server, testKey)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if count, err := client.CountEntries(context.Background(), "m1icxbf0bwgo0d", Filter{}); err != nil || count != 42 {
+				t.Errorf("expected 42 entries, got %d %v", count, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCountEntriesErrors(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetResponse("limited", wufootest.Response{Status: 429, Header: map[string]string{"Retry-After": "3"}})
+	server.SetResponse("broken", wufootest.Response{Status: 500})
+	server.SetResponse("gateway", wufootest.Response{Status: 504})
+	server.SetResponse("garbage", wufootest.Response{Body: `{"EntryCount":`})
+	server.SetResponse("html", wufootest.Response{ContentType: "text/html", Body: "<html>Maintenance</html>"})
+	server.SetResponse("empty", wufootest.Response{Body: `{"EntryCount":""}`})
+	server.SetResponse("negative", wufootest.Response{Body: `{"EntryCount":"-1"}`})
+	server.SetResponse("slow", wufootest.Response{Body: `{"EntryCount":"1"}`, Delay: 3 * time.Second})
+	server.SetCount("private", 1)
+
+	tests := []struct {
+		formId    string
+		apiKey    string
+		code      string
+		retryable bool
+	}{
+		{"private", "WXYZ-WXYZ-WXYZ-WXYZ", ErrAuthFailed, false},
+		{"missing", testKey, ErrFormNotFound, false},
+		{"limited", testKey, ErrRateLimited, true},
+		{"broken", testKey, ErrUpstream, true},
+		{"gateway", testKey, ErrUpstreamTimeout, true},
+		{"garbage", testKey, ErrMalformedResponse, false},
+		{"html", testKey, ErrMalformedResponse, false},
+		{"empty", testKey, ErrMalformedResponse, false},
+		{"negative", testKey, ErrMalformedResponse, false},
+		{"slow", testKey, ErrUpstreamTimeout, true},
+	}
+	for _, test := range tests {
+		_, err := newTestClient(t, server, test.apiKey).CountEntries(context.Background(), test.formId, Filter{})
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: expected *Error, got %v", test.formId, err)
+			continue
+		}
+		if e.Code != test.code || e.Retryable != test.retryable || e.FormId != test.formId || e.Account != "railsgirlssb" {
+			t.Errorf("%s: expected %s (retryable %t), got %#v", test.formId, test.code, test.retryable, e)
+		}
+	}
+}
+
+func TestCountEntriesRetryAfter(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetResponse("limited", wufootest.Response{Status: 429, Header: map[string]string{"Retry-After": "3"}})
+
+	_, err := newTestClient(t, server, testKey).CountEntries(context.Background(), "limited", Filter{})
+	if e, ok := err.(*Error); !ok || e.RetryAfter != 3*time.Second {
+		t.Errorf("expected to be asked to retry after 3s, got %v", err)
+	}
+}
+
+func TestCountEntriesCancel(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetResponse("slow", wufootest.Response{Body: `{"EntryCount":"1"}`, Delay: 3 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestClient(t, server, testKey).CountEntries(ctx, "slow", Filter{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request wasn't aborted, took %s", elapsed)
+	}
+}
+
+func TestCountEntriesFilter(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetCount("m1icxbf0bwgo0d", 7)
+
+	filter := Filter{Rules: []string{"Field3 Is_equal_to Berlin", "DateCreated Is_after 2015-03-01 00:00:00"}, Match: "OR"}
+	if _, err := newTestClient(t, server, testKey).CountEntries(context.Background(), "m1icxbf0bwgo0d", filter); err != nil {
+		t.Fatal(err)
+	}
+	query := server.Query("m1icxbf0bwgo0d")
+	if query.Get("Filter1") != "Field3 Is_equal_to Berlin" || query.Get("Filter2") != "DateCreated Is_after 2015-03-01 00:00:00" || query.Get("match") != "OR" {
+		t.Errorf("unexpected filter parameters %v", query)
+	}
+}
+
+func TestGetForm(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetForm("m1icxbf0bwgo0d", map[string]string{"Name": "Applicants", "Hash": "m1icxbf0bwgo0d", "EntryLimit": "60"})
+
+	client := newTestClient(t, server, testKey)
+	form, err := client.GetForm(context.Background(), "m1icxbf0bwgo0d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Name != "Applicants" || form.EntryLimit != "60" {
+		t.Errorf("unexpected form %#v", form)
+	}
+	if _, err := client.GetForm(context.Background(), "missing"); err == nil || err.(*Error).Code != ErrFormNotFound {
+		t.Errorf("expected %s, got %v", ErrFormNotFound, err)
+	}
+}
+
+func TestListForms(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetForm("m1icxbf0bwgo0d", map[string]string{"Name": "Applicants"})
+	server.SetForm("z19dvb0e0iu9oln", map[string]string{"Name": "Coaches"})
+
+	forms, err := newTestClient(t, server, testKey).ListForms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) != 2 || forms[0].Name != "Applicants" || forms[1].Name != "Coaches" {
+		t.Errorf("unexpected forms %v", forms)
+	}
+	if _, err := newTestClient(t, server, "WXYZ-WXYZ-WXYZ-WXYZ").ListForms(context.Background()); err == nil || err.(*Error).Code != ErrAuthFailed {
+		t.Errorf("expected %s, got %v", ErrAuthFailed, err)
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	var entries []map[string]string
+	for i := 1; i <= 30; i++ {
+		entries = append(entries, map[string]string{"EntryId": strconv.Itoa(i)})
+	}
+	server.SetEntries("m1icxbf0bwgo0d", entries)
+
+	client := newTestClient(t, server, testKey)
+	tests := []struct {
+		page  Page
+		first string
+		count int
+	}{
+		{Page{}, "1", 25},
+		{Page{Start: 25}, "26", 5},
+		{Page{Start: 10, Size: 5}, "11", 5},
+		{Page{Start: 30}, "", 0},
+	}
+	for _, test := range tests {
+		page, err := client.ListEntries(context.Background(), "m1icxbf0bwgo0d", test.page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != test.count || test.count > 0 && page[0]["EntryId"] != test.first {
+			t.Errorf("%+v: expected %d entries from %s, got %v", test.page, test.count, test.first, page)
+		}
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	server := wufootest.NewServer(testKey)
+	defer server.Close()
+	server.SetVersion("v4")
+	server.SetCount("m1icxbf0bwgo0d", 42)
+
+	if count, err := newTestClient(t, server, testKey).CountEntries(context.Background(), "m1icxbf0bwgo0d", Filter{}); err != nil || count != 42 {
+		t.Errorf("expected 42 entries from v4, got %d %v", count, err)
+	}
+	client, _ := New("railsgirlssb", testKey, "any", Options{Timeout: time.Second, BaseURL: server.URL + "/api/v3"})
+	if _, err := client.CountEntries(context.Background(), "m1icxbf0bwgo0d", Filter{}); err == nil {
+		t.Error("expected v3 to be unavailable")
+	}
+}
@@ -0,0 +1,77 @@
+// Package wufoo is a small client for the Wufoo API v3.
+package wufoo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Client is the part of the Wufoo API this app uses.
+type Client interface {
+	// CountEntries returns the number of entries submitted to a form that
+	// match filter.
+	CountEntries(ctx context.Context, formId string, filter Filter) (int, error)
+	// GetForm returns a single form.
+	GetForm(ctx context.Context, formId string) (Form, error)
+	// ListForms returns all forms of the account.
+	ListForms(ctx context.Context) ([]Form, error)
+	// ListEntries returns one page of the entries of a form.
+	ListEntries(ctx context.Context, formId string, page Page) ([]Entry, error)
+}
+
+// Form is a form as described by Wufoo.
+type Form struct {
+	Name             string
+	Description      string
+	Url              string
+	Hash             string
+	IsPublic         string
+	Language         string
+	StartDate        string
+	EndDate          string
+	EntryLimit       string
+	DateCreated      string
+	DateUpdated      string
+	LinkFields       string
+	LinkEntries      string
+	LinkEntriesCount string
+}
+
+// Entry maps field IDs like "Field1" or "DateCreated" to their values.
+type Entry map[string]string
+
+// Page selects a range of entries. Wufoo returns at most 100 entries per page.
+type Page struct {
+	Start int
+	Size  int
+}
+
+// Error codes of failed requests.
+const (
+	ErrAuthFailed        = "auth_failed"
+	ErrFormNotFound      = "form_not_found"
+	ErrRateLimited       = "rate_limited"
+	ErrUpstreamTimeout   = "upstream_timeout"
+	ErrMalformedResponse = "malformed_response"
+	ErrUpstream          = "upstream_error"
+)
+
+// Error is a failed request to Wufoo. Retryable errors are worth another
+// attempt, after RetryAfter if Wufoo asked for it.
+type Error struct {
+	Code       string
+	Account    string
+	FormId     string
+	Err        error
+	Retryable  bool
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s form %s: %s: %s", e.Account, e.FormId, e.Code, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
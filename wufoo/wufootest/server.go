@@ -0,0 +1,253 @@
+// Package wufootest provides a local stand-in for the Wufoo API.
+package wufootest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response is a canned answer for one form.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        string
+	Header      map[string]string
+	// Delay holds the response back, to simulate a slow Wufoo.
+	Delay time.Duration
+}
+
+// Server serves the forms and entries endpoints of the Wufoo API under
+// /api/{version}. Counts come from programmable responses, forms and
+// entries from SetForm and SetEntries. Unknown forms answer 404 like Wufoo
+// does.
+type Server struct {
+	*httptest.Server
+
+	sync.Mutex
+	version   string
+	responses map[string]Response
+	forms     map[string]map[string]string
+	entries   map[string][]map[string]string
+	requests  map[string]int
+	queries   map[string]url.Values
+	apiKey    string
+}
+
+var (
+	formsPath   = regexp.MustCompile(`^/api/([^/]+)/forms\.json$`)
+	formPath    = regexp.MustCompile(`^/api/([^/]+)/forms/([^/]+)\.json$`)
+	entriesPath = regexp.MustCompile(`^/api/([^/]+)/forms/([^/]+)/entries\.json$`)
+	countPath   = regexp.MustCompile(`^/api/([^/]+)/forms/([^/]+)/entries/count\.json$`)
+)
+
+// NewServer starts a server for API version v3 that accepts apiKey as its
+// only valid key.
+func NewServer(apiKey string) *Server {
+	s := &Server{
+		version:   "v3",
+		responses: map[string]Response{},
+		forms:     map[string]map[string]string{},
+		entries:   map[string][]map[string]string{},
+		requests:  map[string]int{},
+		queries:   map[string]url.Values{},
+		apiKey:    apiKey,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// BaseURL is what the wufoo client should use instead of the real API.
+func (s *Server) BaseURL() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.URL + "/api/" + s.version
+}
+
+// SetVersion makes the server answer under /api/{version} only.
+func (s *Server) SetVersion(version string) {
+	s.Lock()
+	defer s.Unlock()
+	s.version = version
+}
+
+// SetCount answers requests for formId with count.
+func (s *Server) SetCount(formId string, count int) {
+	s.SetResponse(formId, Response{Body: fmt.Sprintf(`{"EntryCount":"%d"}`, count)})
+}
+
+// SetResponse answers count requests for formId with resp. A zero status
+// means 200 and an empty content type means JSON. Without one the number of
+// entries set with SetEntries is returned.
+func (s *Server) SetResponse(formId string, resp Response) {
+	s.Lock()
+	defer s.Unlock()
+	s.responses[formId] = resp
+}
+
+// SetForm describes formId with fields like "Name" or "Url".
+func (s *Server) SetForm(formId string, fields map[string]string) {
+	s.Lock()
+	defer s.Unlock()
+	s.forms[formId] = fields
+}
+
+// SetEntries sets the entries of formId, which are served a page at a time.
+func (s *Server) SetEntries(formId string, entries []map[string]string) {
+	s.Lock()
+	defer s.Unlock()
+	s.entries[formId] = entries
+}
+
+// Requests returns how often formId was requested, or the list of forms
+// for the empty form ID.
+func (s *Server) Requests(formId string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.requests[formId]
+}
+
+// Query returns the query parameters, like entry filters, of the latest
+// request for formId.
+func (s *Server) Query(formId string) url.Values {
+	s.Lock()
+	defer s.Unlock()
+	return s.queries[formId]
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	resp, ok := s.respond(req)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	if len(resp.ContentType) < 1 {
+		resp.ContentType = "application/json"
+	}
+	w.Header().Set("Content-Type", resp.ContentType)
+	for name, value := range resp.Header {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(resp.Status)
+	w.Write([]byte(strings.TrimSpace(resp.Body)))
+}
+
+// respond records req and picks its answer. It returns false for paths
+// outside the API.
+func (s *Server) respond(req *http.Request) (Response, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	var formId string
+	var resp Response
+	if match := s.match(formsPath, req.URL.Path); match != nil {
+		resp = jsonResponse(map[string]interface{}{"Forms": s.formList()})
+	} else if match := s.match(formPath, req.URL.Path); match != nil {
+		formId = match[2]
+		resp = jsonResponse(map[string]interface{}{"Forms": []map[string]string{s.form(formId)}})
+	} else if match := s.match(entriesPath, req.URL.Path); match != nil {
+		formId = match[2]
+		resp = jsonResponse(map[string]interface{}{"Entries": s.page(formId, req.URL.Query())})
+	} else if match := s.match(countPath, req.URL.Path); match != nil {
+		formId = match[2]
+		var ok bool
+		if resp, ok = s.responses[formId]; !ok {
+			resp = Response{Body: fmt.Sprintf(`{"EntryCount":"%d"}`, len(s.entries[formId]))}
+		}
+	} else {
+		return Response{}, false
+	}
+	s.requests[formId]++
+	s.queries[formId] = req.URL.Query()
+
+	if user, _, _ := req.BasicAuth(); user != s.apiKey {
+		resp = Response{Status: http.StatusUnauthorized, Body: `{"HTTPCode":401,"Text":"You must authenticate to get at the goodies."}`}
+	} else if len(formId) > 0 && !s.known(formId) {
+		resp = Response{Status: http.StatusNotFound, Body: `{"HTTPCode":404,"Text":"The form was not found."}`}
+	}
+	return resp, true
+}
+
+// match returns the submatches of pattern in path if it is under the
+// server's API version.
+func (s *Server) match(pattern *regexp.Regexp, path string) []string {
+	if match := pattern.FindStringSubmatch(path); match != nil && match[1] == s.version {
+		return match
+	}
+	return nil
+}
+
+func (s *Server) known(formId string) bool {
+	_, counted := s.responses[formId]
+	_, described := s.forms[formId]
+	_, hasEntries := s.entries[formId]
+	return counted || described || hasEntries
+}
+
+// form returns the description of formId, made up from its ID if none was set.
+func (s *Server) form(formId string) map[string]string {
+	if form, ok := s.forms[formId]; ok {
+		return form
+	}
+	return map[string]string{"Name": formId, "Hash": formId}
+}
+
+func (s *Server) formList() []map[string]string {
+	var formIds []string
+	for formId := range s.forms {
+		formIds = append(formIds, formId)
+	}
+	sort.Strings(formIds)
+	forms := []map[string]string{}
+	for _, formId := range formIds {
+		forms = append(forms, s.forms[formId])
+	}
+	return forms
+}
+
+// page returns the entries selected by the pageStart and pageSize
+// parameters. Like Wufoo it returns 25 entries unless asked for more, and
+// at most 100.
+func (s *Server) page(formId string, query url.Values) []map[string]string {
+	entries := s.entries[formId]
+	start, _ := strconv.Atoi(query.Get("pageStart"))
+	size, err := strconv.Atoi(query.Get("pageSize"))
+	if err != nil || size < 1 {
+		size = 25
+	} else if size > 100 {
+		size = 100
+	}
+	if start < 0 || start >= len(entries) {
+		return []map[string]string{}
+	}
+	end := start + size
+	if end > len(entries) {
+		end = len(entries)
+	}
+	return entries[start:end]
+}
+
+func jsonResponse(v interface{}) Response {
+	body, _ := json.Marshal(v)
+	return Response{Body: string(body)}
+}